@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	//"fmt"
+	"log/slog"
 	"net/http"
 	"nixon/internal/api"
 	"nixon/internal/config"
 	"nixon/internal/control"
+	"nixon/internal/db"
 	"nixon/internal/slogger"
 	"nixon/internal/websocket"
 	"os"
@@ -17,16 +19,26 @@ import (
 
 func main() {
 	slogger.InitSlogger()
+	// Mirror log records to WebSocket clients subscribed to logs.
+	slogger.Log = slog.New(websocket.NewLogHandler(slogger.Log.Handler()))
 	config.LoadConfig()
 
+	if err := db.Init(config.AppConfig.Database.Path); err != nil {
+		slogger.Log.Error("Error initializing database", "err", err, "path", config.AppConfig.Database.Path)
+		os.Exit(1)
+	}
+
 	ctrl, err := control.GetManager()
 	if err != nil {
 		slogger.Log.Error("Error initializing control manager", "err", err)
 		os.Exit(1)
 	}
 
-	ctrl.StartAudio()
-	go websocket.HandleMessages()
+	ctrl.StartExports()
+	if err := ctrl.StartAudio(); err != nil {
+		slogger.Log.Error("Error starting audio engine", "err", err)
+		os.Exit(1)
+	}
 
 	router := api.NewRouter(ctrl)
 
@@ -49,6 +61,13 @@ func main() {
 	<-stopChan
 	slogger.Log.Info("Shutdown signal received, starting graceful shutdown...")
 
+	// Stop the engine first so any take in progress is finalized before the
+	// process exits.
+	if err := ctrl.StopAudio(); err != nil {
+		slogger.Log.Error("Audio engine shutdown failed", "err", err)
+	}
+	ctrl.StopExports()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
@@ -9,6 +9,7 @@ require (
 	github.com/go-playground/validator/v10 v10.28.0
 	github.com/gorilla/websocket v1.5.1
 	github.com/spf13/viper v1.21.0
+	golang.org/x/sync v0.17.0
 	gorm.io/driver/sqlite v1.6.0
 	gorm.io/gorm v1.30.0
 )
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"nixon/internal/common"
+	"nixon/internal/control"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// exportID parses the {exportID} URL parameter, writing a 400 response if it is invalid.
+func exportID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "exportID"), 10, 32)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err, "Invalid export ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// handleExportRecording queues an export and responds with the job, whose
+// progress is then reported over the WebSocket as job_updated events.
+func handleExportRecording(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		var body common.ExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Validation failed: "+err.Error())
+			return
+		}
+		job, err := ctrl.ExportRecording(id, body)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to export recording")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+// handleGetExports lists export jobs, newest first, optionally only those of
+// the recording given by the recording query parameter.
+func handleGetExports(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var recordingID uint64
+		if v := r.URL.Query().Get("recording"); v != "" {
+			var err error
+			if recordingID, err = strconv.ParseUint(v, 10, 32); err != nil {
+				respondWithError(w, http.StatusBadRequest, err, "Invalid recording ID")
+				return
+			}
+		}
+		jobs, err := ctrl.GetExportJobs(uint(recordingID))
+		if err != nil {
+			respondWithControlError(w, err, "Failed to get exports")
+			return
+		}
+		if jobs == nil {
+			jobs = []common.ExportJob{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jobs)
+	}
+}
+
+func handleGetExportFormats(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ctrl.ExportFormats())
+	}
+}
+
+func handleGetExport(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := exportID(w, r)
+		if !ok {
+			return
+		}
+		job, err := ctrl.GetExportJob(id)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to get export")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+func handleDeleteExport(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := exportID(w, r)
+		if !ok {
+			return
+		}
+		if err := ctrl.DeleteExportJob(id); err != nil {
+			respondWithControlError(w, err, "Failed to delete export")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleDownloadExport serves the file of a finished export as an attachment.
+func handleDownloadExport(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := exportID(w, r)
+		if !ok {
+			return
+		}
+		file, err := ctrl.OpenExport(id)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to open export")
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("Content-Type", file.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+		w.Header().Set("ETag", file.ETag)
+		http.ServeContent(w, r, file.Name, file.ModTime, file)
+	}
+}
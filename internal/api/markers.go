@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/control"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// markerRequest is the body of a new marker. Offsets are in nanoseconds,
+// like the durations of a recording.
+type markerRequest struct {
+	Offset time.Duration `json:"offset" validate:"min=0"`
+	End    time.Duration `json:"end" validate:"min=0"`
+	Label  string        `json:"label" validate:"max=256"`
+	Color  string        `json:"color" validate:"omitempty,hexcolor"`
+}
+
+// dropMarkerRequest is the optional body of a marker dropped at the current
+// position of the take being recorded.
+type dropMarkerRequest struct {
+	Label string `json:"label" validate:"max=256"`
+	Color string `json:"color" validate:"omitempty,hexcolor"`
+}
+
+// markerID parses the {markerID} URL parameter, writing a 400 response if it is invalid.
+func markerID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "markerID"), 10, 32)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err, "Invalid marker ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func handleGetMarkers(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		markers, err := ctrl.GetMarkers(id)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to get markers")
+			return
+		}
+		if markers == nil {
+			markers = []common.Marker{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(markers)
+	}
+}
+
+func handleAddMarker(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		var body markerRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Validation failed: "+err.Error())
+			return
+		}
+		marker, err := ctrl.AddMarker(common.Marker{
+			RecordingID: id,
+			Offset:      body.Offset,
+			End:         body.End,
+			Label:       body.Label,
+			Color:       body.Color,
+		})
+		if err != nil {
+			respondWithControlError(w, err, "Failed to add marker")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(marker)
+	}
+}
+
+func handleUpdateMarker(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		mid, ok := markerID(w, r)
+		if !ok {
+			return
+		}
+		var body common.MarkerUpdate
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Validation failed: "+err.Error())
+			return
+		}
+		marker, err := ctrl.UpdateMarker(id, mid, body)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to update marker")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(marker)
+	}
+}
+
+func handleDeleteMarker(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		mid, ok := markerID(w, r)
+		if !ok {
+			return
+		}
+		if err := ctrl.DeleteMarker(id, mid); err != nil {
+			respondWithControlError(w, err, "Failed to delete marker")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleDropMarker marks the current position of the take being recorded.
+func handleDropMarker(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body dropMarkerRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				respondWithError(w, http.StatusBadRequest, err, "Invalid request body")
+				return
+			}
+		}
+		if err := validate.Struct(body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Validation failed: "+err.Error())
+			return
+		}
+		marker, err := ctrl.DropMarker(body.Label, body.Color)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to drop marker")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(marker)
+	}
+}
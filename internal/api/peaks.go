@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"nixon/internal/control"
+	"nixon/internal/peaks"
+)
+
+// handleGetPeaks serves the waveform of a recording in audiowaveform's
+// format, readable by peaks.js and similar viewers. Query parameters:
+//
+//	resolution  samples per pixel: 256 times a power of two, up to 65536 (default 256)
+//	format      json (default) or dat for the binary format
+//	bits        16 (default) or 8
+func handleGetPeaks(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		resolution, bits := peaks.BaseSamplesPerPixel, 16
+		if v := q.Get("resolution"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, err, "Invalid resolution")
+				return
+			}
+			resolution = n
+		}
+		switch q.Get("bits") {
+		case "", "16":
+		case "8":
+			bits = 8
+		default:
+			respondWithError(w, http.StatusBadRequest, errors.New("bits must be 8 or 16"), "Invalid bits")
+			return
+		}
+		format := q.Get("format")
+		if format != "" && format != "json" && format != "dat" {
+			respondWithError(w, http.StatusBadRequest, errors.New("format must be json or dat"), "Invalid format")
+			return
+		}
+
+		p, err := ctrl.GetPeaks(id, resolution)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to get waveform")
+			return
+		}
+		if format == "dat" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			p.WriteBinary(w, bits)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		p.WriteJSON(w, bits)
+	}
+}
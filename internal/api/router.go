@@ -4,8 +4,11 @@ import (
 	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
+	"math"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -14,6 +17,7 @@ import (
 	"strconv"
 	"time"
 
+	"nixon/internal/common"
 	"nixon/internal/config"
 	"nixon/internal/control"
 	"nixon/internal/slogger"
@@ -57,6 +61,39 @@ func respondWithError(w http.ResponseWriter, status int, err error, message stri
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
+// respondWithControlError maps an error from the control layer to an HTTP
+// status. Requests that conflict with the recorder's current state get a 409
+// with the reason appended to message.
+func respondWithControlError(w http.ResponseWriter, err error, message string) {
+	status := controlErrorStatus(err)
+	if status != http.StatusInternalServerError {
+		message += ": " + err.Error()
+	}
+	respondWithError(w, status, err, message)
+}
+
+// controlErrorStatus returns the HTTP status for an error from the control
+// layer. Unexpected errors are internal server errors.
+func controlErrorStatus(err error) int {
+	var te *control.TransitionError
+	switch {
+	case errors.As(err, &te):
+		return http.StatusConflict
+	case errors.Is(err, control.ErrRecordingInUse), errors.Is(err, control.ErrExportNotReady):
+		return http.StatusConflict
+	case errors.Is(err, control.ErrInvalidMarker), errors.Is(err, control.ErrUnsupportedFormat),
+		errors.Is(err, control.ErrInvalidResolution):
+		return http.StatusBadRequest
+	case errors.Is(err, control.ErrRecordingNotFound), errors.Is(err, control.ErrMarkerNotFound),
+		errors.Is(err, control.ErrExportNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, control.ErrAudioNotRunning):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // wsAuthMiddleware protects the WebSocket endpoint with a token.
 func wsAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -79,12 +116,34 @@ func wsAuthMiddleware(next http.Handler) http.Handler {
 func apiRouter(ctrl *control.Manager) http.Handler {
 	r := chi.NewRouter()
 	r.Get("/status", handleGetStatus(ctrl))
+	r.Get("/state/history", handleGetStateHistory(ctrl))
 	r.Post("/stream/start", handleStreamStart(ctrl))
 	r.Post("/stream/stop", handleStreamStop(ctrl))
 	r.Post("/recording/start", handleRecordingStart(ctrl))
 	r.Post("/recording/stop", handleRecordingStop(ctrl))
+	r.Post("/recording/pause", handleRecordingPause(ctrl))
+	r.Post("/recording/resume", handleRecordingResume(ctrl))
+	r.Post("/recording/marker", handleDropMarker(ctrl))
 	r.Get("/recordings", handleGetRecordings(ctrl))
+	r.Post("/recordings/tags", handleTagRecordings(ctrl, true))
+	r.Delete("/recordings/tags", handleTagRecordings(ctrl, false))
+	r.Get("/tags", handleGetTags(ctrl))
+	r.Get("/recording/{id}", handleGetRecording(ctrl))
+	r.Patch("/recording/{id}", handleUpdateRecording(ctrl))
+	r.Get("/recording/{id}/audio", handleRecordingAudio(ctrl))
+	r.Get("/recording/{id}/peaks", handleGetPeaks(ctrl))
 	r.Delete("/recording/{id}", handleDeleteRecording(ctrl))
+	r.Get("/recording/{id}/markers", handleGetMarkers(ctrl))
+	r.Post("/recording/{id}/markers", handleAddMarker(ctrl))
+	r.Patch("/recording/{id}/markers/{markerID}", handleUpdateMarker(ctrl))
+	r.Delete("/recording/{id}/markers/{markerID}", handleDeleteMarker(ctrl))
+	r.Post("/recording/{id}/export", handleExportRecording(ctrl))
+	r.Get("/exports", handleGetExports(ctrl))
+	r.Get("/exports/formats", handleGetExportFormats(ctrl))
+	r.Get("/export/{exportID}", handleGetExport(ctrl))
+	r.Delete("/export/{exportID}", handleDeleteExport(ctrl))
+	r.Get("/export/{exportID}/download", handleDownloadExport(ctrl))
+	r.Handle("/metrics", expvar.Handler()) // runtime stats and websocket_clients
 	return r
 }
 
@@ -98,6 +157,7 @@ func NewRouter(ctrl *control.Manager) *chi.Mux {
 
 	// --- Application Routes ---
 	r.Mount("/api", apiRouter(ctrl))
+	registerMessageHandlers(ctrl)
 	r.With(wsAuthMiddleware).Get("/ws", websocket.Handler)
 
 	// --- Frontend Handling (Proxy for Dev, Static for Prod) ---
@@ -149,11 +209,21 @@ func handleGetStatus(ctrl *control.Manager) http.HandlerFunc {
 	}
 }
 
+func handleGetStateHistory(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ctrl.GetStateHistory())
+	}
+}
+
+// streamRequest selects the stream to start or stop.
+type streamRequest struct {
+	Type string `json:"type" validate:"required,oneof=srt icecast"`
+}
+
 func handleStreamStart(ctrl *control.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var body struct {
-			Type string `json:"type" validate:"required,oneof=srt icecast"`
-		}
+		var body streamRequest
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			respondWithError(w, http.StatusBadRequest, err, "Invalid request body")
 			return
@@ -163,7 +233,7 @@ func handleStreamStart(ctrl *control.Manager) http.HandlerFunc {
 			return
 		}
 		if err := ctrl.StartStream(body.Type); err != nil {
-			respondWithError(w, http.StatusInternalServerError, err, "Failed to start stream")
+			respondWithControlError(w, err, "Failed to start stream")
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -172,9 +242,7 @@ func handleStreamStart(ctrl *control.Manager) http.HandlerFunc {
 
 func handleStreamStop(ctrl *control.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var body struct {
-			Type string `json:"type" validate:"required,oneof=srt icecast"`
-		}
+		var body streamRequest
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			respondWithError(w, http.StatusBadRequest, err, "Invalid request body")
 			return
@@ -184,7 +252,7 @@ func handleStreamStop(ctrl *control.Manager) http.HandlerFunc {
 			return
 		}
 		if err := ctrl.StopStream(body.Type); err != nil {
-			respondWithError(w, http.StatusInternalServerError, err, "Failed to stop stream")
+			respondWithControlError(w, err, "Failed to stop stream")
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -194,7 +262,7 @@ func handleStreamStop(ctrl *control.Manager) http.HandlerFunc {
 func handleRecordingStart(ctrl *control.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := ctrl.StartRecording(); err != nil {
-			respondWithError(w, http.StatusInternalServerError, err, "Failed to start recording")
+			respondWithControlError(w, err, "Failed to start recording")
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -204,34 +272,346 @@ func handleRecordingStart(ctrl *control.Manager) http.HandlerFunc {
 func handleRecordingStop(ctrl *control.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := ctrl.StopRecording(); err != nil {
-			respondWithError(w, http.StatusInternalServerError, err, "Failed to stop recording")
+			respondWithControlError(w, err, "Failed to stop recording")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func handleRecordingPause(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := ctrl.PauseRecording(); err != nil {
+			respondWithControlError(w, err, "Failed to pause recording")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func handleRecordingResume(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := ctrl.ResumeRecording(); err != nil {
+			respondWithControlError(w, err, "Failed to resume recording")
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
+// handleGetRecordings lists recordings, newest first. Query parameters:
+//
+//	session=ID            parts of one split take, in order
+//	from=, to=            StartTime range, RFC 3339 or YYYY-MM-DD (to is inclusive of that day)
+//	minDuration=, maxDuration=  Go durations ("90s", "1h") or seconds
+//	genre=                exact genre, ignoring case
+//	q=                    text search in notes and genre
+//	tag=                  recordings with this tag; repeat to require several
+//	favorite=true         favorites only
+//	minRating=N           rated N or higher
+//	minLoudness=, maxLoudness=  integrated loudness in LUFS
+//	minTruePeak=, maxTruePeak=  true peak in dBTP, e.g. minTruePeak=-1 for clipped takes
+//	sort=                 id, startTime, duration, fileSize, filename, genre, part, rating,
+//	                      loudness, loudnessRange, samplePeak or truePeak
+//	order=                asc or desc
+//	limit=, offset=       page size (default 100, max 1000) and position
+//
+// The total number of matches is returned in the X-Total-Count header.
 func handleGetRecordings(ctrl *control.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		recordings, err := ctrl.GetRecordings()
+		q, err := parseRecordingQuery(r.URL.Query())
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Invalid query: "+err.Error())
+			return
+		}
+		if err := validate.Struct(q); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Validation failed: "+err.Error())
+			return
+		}
+
+		recordings, total, err := ctrl.GetRecordings(q)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, err, "Failed to get recordings")
 			return
 		}
+		if recordings == nil {
+			recordings = []common.Recording{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
 		json.NewEncoder(w).Encode(recordings)
 	}
 }
 
-func handleDeleteRecording(ctrl *control.Manager) http.HandlerFunc {
+// defaultPageSize is the number of recordings listed when no limit is given.
+const defaultPageSize = 100
+
+// parseRecordingQuery reads the list filters from the URL query string.
+func parseRecordingQuery(v url.Values) (common.RecordingQuery, error) {
+	q := common.RecordingQuery{
+		Genre:  v.Get("genre"),
+		Search: v.Get("q"),
+		Tags:   v["tag"],
+		Sort:   v.Get("sort"),
+		Limit:  defaultPageSize,
+	}
+
+	var err error
+	if s := v.Get("session"); s != "" {
+		id, perr := strconv.ParseUint(s, 10, 32)
+		if perr != nil {
+			return q, fmt.Errorf("session: %w", perr)
+		}
+		q.Session = uint(id)
+	}
+	if s := v.Get("favorite"); s != "" {
+		if q.Favorite, err = strconv.ParseBool(s); err != nil {
+			return q, fmt.Errorf("favorite: %w", err)
+		}
+	}
+	if s := v.Get("minRating"); s != "" {
+		if q.MinRating, err = strconv.Atoi(s); err != nil {
+			return q, fmt.Errorf("minRating: %w", err)
+		}
+	}
+	if s := v.Get("from"); s != "" {
+		if q.From, _, err = parseQueryTime(s); err != nil {
+			return q, fmt.Errorf("from: %w", err)
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		var dateOnly bool
+		if q.To, dateOnly, err = parseQueryTime(s); err != nil {
+			return q, fmt.Errorf("to: %w", err)
+		}
+		if dateOnly {
+			q.To = q.To.AddDate(0, 0, 1)
+		}
+	}
+	if s := v.Get("minDuration"); s != "" {
+		if q.MinDuration, err = parseQueryDuration(s); err != nil {
+			return q, fmt.Errorf("minDuration: %w", err)
+		}
+	}
+	if s := v.Get("maxDuration"); s != "" {
+		if q.MaxDuration, err = parseQueryDuration(s); err != nil {
+			return q, fmt.Errorf("maxDuration: %w", err)
+		}
+	}
+	if s := v.Get("minLoudness"); s != "" {
+		if q.MinLoudness, err = parseQueryLevel(s); err != nil {
+			return q, fmt.Errorf("minLoudness: %w", err)
+		}
+	}
+	if s := v.Get("maxLoudness"); s != "" {
+		if q.MaxLoudness, err = parseQueryLevel(s); err != nil {
+			return q, fmt.Errorf("maxLoudness: %w", err)
+		}
+	}
+	if s := v.Get("minTruePeak"); s != "" {
+		if q.MinTruePeak, err = parseQueryLevel(s); err != nil {
+			return q, fmt.Errorf("minTruePeak: %w", err)
+		}
+	}
+	if s := v.Get("maxTruePeak"); s != "" {
+		if q.MaxTruePeak, err = parseQueryLevel(s); err != nil {
+			return q, fmt.Errorf("maxTruePeak: %w", err)
+		}
+	}
+	switch v.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("order must be asc or desc")
+	}
+	if q.Sort == "" && v.Get("order") != "" {
+		q.Sort = "startTime"
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			return q, fmt.Errorf("limit: %w", err)
+		}
+	}
+	if s := v.Get("offset"); s != "" {
+		if q.Offset, err = strconv.Atoi(s); err != nil {
+			return q, fmt.Errorf("offset: %w", err)
+		}
+	}
+	return q, nil
+}
+
+// parseQueryLevel parses a level in dB or LUFS.
+func parseQueryLevel(s string) (*float64, error) {
+	level, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(level) || math.IsInf(level, 0) {
+		return nil, fmt.Errorf("%q is not a finite number", s)
+	}
+	return &level, nil
+}
+
+// parseQueryTime accepts an RFC 3339 timestamp or a local calendar date, and
+// reports which it was.
+func parseQueryTime(s string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, true, nil
+	}
+	// Stored times are local, and SQLite compares them as text.
+	t, err := time.Parse(time.RFC3339, s)
+	return t.Local(), false, err
+}
+
+// parseQueryDuration accepts a Go duration string or a number of seconds.
+func parseQueryDuration(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// tagRequest is the body of the bulk tag routes.
+type tagRequest struct {
+	IDs  []uint   `json:"ids" validate:"required,min=1,max=1000"`
+	Tags []string `json:"tags" validate:"required,min=1,max=64,dive,min=1,max=32"`
+}
+
+// handleTagRecordings adds (POST) or removes (DELETE) tags on a set of recordings.
+func handleTagRecordings(ctrl *control.Manager, add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body tagRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Validation failed: "+err.Error())
+			return
+		}
+
+		var err error
+		if add {
+			err = ctrl.TagRecordings(body.IDs, body.Tags)
+		} else {
+			err = ctrl.UntagRecordings(body.IDs, body.Tags)
+		}
+		if err != nil {
+			respondWithControlError(w, err, "Failed to update tags")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func handleGetTags(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tags, err := ctrl.GetTags()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err, "Failed to get tags")
+			return
+		}
+		if tags == nil {
+			tags = []common.Tag{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+	}
+}
+
+// recordingID parses the {id} URL parameter, writing a 400 response if it is invalid.
+func recordingID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err, "Invalid recording ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func handleGetRecording(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		rec, err := ctrl.GetRecording(id)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to get recording")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rec)
+	}
+}
+
+func handleUpdateRecording(ctrl *control.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idStr := chi.URLParam(r, "id")
-		id, err := strconv.ParseUint(idStr, 10, 32)
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		var body common.RecordingUpdate
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+		if err := validate.Struct(body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err, "Validation failed: "+err.Error())
+			return
+		}
+		rec, err := ctrl.UpdateRecording(id, body)
 		if err != nil {
-			respondWithError(w, http.StatusBadRequest, err, "Invalid recording ID")
+			respondWithControlError(w, err, "Failed to update recording")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rec)
+	}
+}
+
+// handleRecordingAudio serves a recording's audio file. Range, If-Range and
+// conditional requests are handled by http.ServeContent so browsers can seek
+// in an <audio> element. A take still being written is served as a snapshot
+// of what has been captured so far; ?download=1 asks for an attachment.
+func handleRecordingAudio(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		audio, err := ctrl.OpenRecordingAudio(id)
+		if err != nil {
+			respondWithControlError(w, err, "Failed to open recording audio")
+			return
+		}
+		defer audio.Close()
+
+		disposition := "inline"
+		if r.URL.Query().Get("download") == "1" {
+			disposition = "attachment"
+		}
+		w.Header().Set("Content-Type", audio.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": audio.Name}))
+		if audio.Live {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("X-Recording-Live", "true")
+		} else {
+			w.Header().Set("ETag", audio.ETag)
+		}
+		http.ServeContent(w, r, audio.Name, audio.ModTime, audio)
+	}
+}
+
+func handleDeleteRecording(ctrl *control.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
 			return
 		}
-		if err := ctrl.DeleteRecording(uint(id)); err != nil {
-			respondWithError(w, http.StatusInternalServerError, err, "Failed to delete recording")
+		if err := ctrl.DeleteRecording(id); err != nil {
+			respondWithControlError(w, err, "Failed to delete recording")
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/control"
+	"nixon/internal/db"
+	"nixon/internal/slogger"
+)
+
+func TestMain(m *testing.M) {
+	slogger.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+func TestRecordingStateConflicts(t *testing.T) {
+	dir := t.TempDir()
+	if err := db.Init(filepath.Join(dir, "nixon.db")); err != nil {
+		t.Fatal(err)
+	}
+	saved := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = saved })
+	config.AppConfig.Audio = config.AudioSettings{
+		SampleRate:    8000,
+		Channels:      1,
+		RecordingsDir: dir,
+		Source:        config.SourceSettings{Type: "silence"},
+		Format:        common.FormatWAV,
+		BitDepth:      16,
+	}
+	config.AppConfig.AutoRec = config.AutoRecord{}
+
+	ctrl, _ := control.GetManager()
+	if err := ctrl.StartAudio(); err != nil {
+		t.Fatalf("StartAudio: %v", err)
+	}
+	defer ctrl.StopAudio()
+	srv := httptest.NewServer(apiRouter(ctrl))
+	defer srv.Close()
+
+	steps := []struct {
+		action string
+		want   int
+	}{
+		{"pause", http.StatusConflict},
+		{"resume", http.StatusConflict},
+		{"stop", http.StatusConflict},
+		{"start", http.StatusOK},
+		{"start", http.StatusConflict},
+		{"resume", http.StatusConflict},
+		{"pause", http.StatusOK},
+		{"pause", http.StatusConflict},
+		{"start", http.StatusConflict},
+		{"resume", http.StatusOK},
+		{"stop", http.StatusOK},
+		{"stop", http.StatusConflict},
+	}
+	for i, step := range steps {
+		resp, err := http.Post(srv.URL+"/recording/"+step.action, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct{ Error string }
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != step.want {
+			t.Fatalf("step %d: %s returned %d (%s), want %d", i, step.action, resp.StatusCode, body.Error, step.want)
+		}
+		if step.want == http.StatusConflict && !strings.Contains(body.Error, "cannot "+step.action+" while") {
+			t.Fatalf("step %d: %s conflict reported as %q", i, step.action, body.Error)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/state/history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var history []common.StateTransition
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	want := []common.StateTransition{
+		{From: common.StateStopped, To: common.StateRecording, Event: "start"},
+		{From: common.StateRecording, To: common.StatePaused, Event: "pause"},
+		{From: common.StatePaused, To: common.StateRecording, Event: "resume"},
+		{From: common.StateRecording, To: common.StateFinalizing, Event: "stop"},
+		{From: common.StateFinalizing, To: common.StateStopped, Event: "finalized"},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("history = %+v, want %d transitions", history, len(want))
+	}
+	for i, tr := range history {
+		if tr.From != want[i].From || tr.To != want[i].To || tr.Event != want[i].Event || tr.Time.IsZero() {
+			t.Fatalf("transition %d = %+v, want %+v", i, tr, want[i])
+		}
+	}
+}
+
+func TestControlErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&control.TransitionError{Event: "pause", From: common.StateStopped}, http.StatusConflict},
+		{fmt.Errorf("wrapped: %w", &control.TransitionError{Event: "start", From: common.StateRecording}), http.StatusConflict},
+		{control.ErrRecordingInUse, http.StatusConflict},
+		{control.ErrRecordingNotFound, http.StatusNotFound},
+		{control.ErrAudioNotRunning, http.StatusServiceUnavailable},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := controlErrorStatus(tt.err); got != tt.want {
+			t.Errorf("controlErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
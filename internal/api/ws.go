@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"nixon/internal/common"
+	"nixon/internal/control"
+	"nixon/internal/websocket"
+)
+
+// registerMessageHandlers wires the commands WebSocket clients may send to the
+// control manager. Each mirrors a REST endpoint and fails with the status that
+// endpoint would respond with; state changes also reach the clients
+// subscribed to them through the usual broadcasts. Subscribing to status
+// starts with a snapshot of the current status.
+func registerMessageHandlers(ctrl *control.Manager) {
+	websocket.OnSubscribe(websocket.TopicStatus, func() (string, any) {
+		return "status_update", ctrl.GetStatus()
+	})
+
+	websocket.OnMessage("get_status", func(json.RawMessage) (any, error) {
+		return ctrl.GetStatus(), nil
+	})
+	websocket.OnMessage("get_state_history", func(json.RawMessage) (any, error) {
+		return ctrl.GetStateHistory(), nil
+	})
+
+	websocket.OnMessage("start_recording", command(ctrl.StartRecording))
+	websocket.OnMessage("stop_recording", command(ctrl.StopRecording))
+	websocket.OnMessage("pause_recording", command(ctrl.PauseRecording))
+	websocket.OnMessage("resume_recording", command(ctrl.ResumeRecording))
+
+	websocket.OnMessage("start_stream", func(payload json.RawMessage) (any, error) {
+		var body streamRequest
+		if err := decodeCommand(payload, &body); err != nil {
+			return nil, err
+		}
+		return nil, controlError(ctrl.StartStream(body.Type))
+	})
+	websocket.OnMessage("stop_stream", func(payload json.RawMessage) (any, error) {
+		var body streamRequest
+		if err := decodeCommand(payload, &body); err != nil {
+			return nil, err
+		}
+		return nil, controlError(ctrl.StopStream(body.Type))
+	})
+
+	websocket.OnMessage("drop_marker", func(payload json.RawMessage) (any, error) {
+		var body dropMarkerRequest
+		if err := decodeCommand(payload, &body); err != nil {
+			return nil, err
+		}
+		marker, err := ctrl.DropMarker(body.Label, body.Color)
+		if err != nil {
+			return nil, controlError(err)
+		}
+		return marker, nil
+	})
+}
+
+// command adapts a control action that takes no arguments and returns no result.
+func command(fn func() error) websocket.MessageHandler {
+	return func(json.RawMessage) (any, error) {
+		return nil, controlError(fn())
+	}
+}
+
+// decodeCommand unmarshals and validates the payload of a command. An empty
+// payload leaves v at its zero value.
+func decodeCommand(payload json.RawMessage, v any) error {
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, v); err != nil {
+			return &common.WSError{Code: http.StatusBadRequest, Message: "Invalid request body: " + err.Error()}
+		}
+	}
+	if err := validate.Struct(v); err != nil {
+		return &common.WSError{Code: http.StatusBadRequest, Message: "Validation failed: " + err.Error()}
+	}
+	return nil
+}
+
+// controlError reports an error from the control layer with the status the
+// REST API would give it.
+func controlError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &common.WSError{Code: controlErrorStatus(err), Message: err.Error()}
+}
@@ -0,0 +1,70 @@
+package audio
+
+import (
+	"io"
+
+	"nixon/internal/wav"
+)
+
+// File is a Source that plays back a WAV file in real time, optionally looping.
+// Sample rate and channel count come from the file itself.
+type File struct {
+	path   string
+	loop   bool
+	reader *wav.Reader
+	info   wav.Info
+	pacer  pacer
+}
+
+// NewFile creates a Source for the WAV file at path. The file header is read
+// immediately so the format is known before Open.
+func NewFile(path string, loop bool) (*File, error) {
+	info, err := wav.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, loop: loop, info: info, pacer: pacer{sampleRate: info.SampleRate}}, nil
+}
+
+// Open opens the file for playback from the beginning.
+func (f *File) Open() error {
+	r, err := wav.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.reader = r
+	f.info = r.Info()
+	f.pacer = pacer{sampleRate: f.info.SampleRate}
+	return nil
+}
+
+// ReadFrames decodes the next frames of the file. At the end of the file it
+// rewinds when looping and returns io.EOF otherwise.
+func (f *File) ReadFrames(buf []float32) (int, error) {
+	n, err := f.reader.ReadFrames(buf)
+	if err == io.EOF && f.loop && f.info.Frames > 0 {
+		f.reader.Rewind()
+		n, err = f.reader.ReadFrames(buf)
+	}
+	if err != nil {
+		return 0, err
+	}
+	f.pacer.wait(n)
+	return n, nil
+}
+
+// SampleRate returns the sample rate of the file in Hz.
+func (f *File) SampleRate() int { return f.info.SampleRate }
+
+// Channels returns the channel count of the file.
+func (f *File) Channels() int { return f.info.Channels }
+
+// Close closes the file.
+func (f *File) Close() error {
+	if f.reader == nil {
+		return nil
+	}
+	err := f.reader.Close()
+	f.reader = nil
+	return err
+}
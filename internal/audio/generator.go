@@ -0,0 +1,96 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// Waveform selects the signal produced by a Generator.
+type Waveform string
+
+const (
+	WaveSilence Waveform = "silence"
+	WaveSine    Waveform = "sine"
+	WaveNoise   Waveform = "noise"
+)
+
+// Generator is a Source that synthesizes a sine tone or white noise in real time.
+// The same signal is written to every channel.
+type Generator struct {
+	sampleRate int
+	channels   int
+	waveform   Waveform
+	frequency  float64
+	amplitude  float32
+	phase      float64
+	pacer      pacer
+}
+
+// NewGenerator creates a Generator. frequency is in Hz and only used by
+// WaveSine; level is the peak level in dBFS.
+func NewGenerator(sampleRate, channels int, waveform Waveform, frequency, level float64) (*Generator, error) {
+	switch waveform {
+	case WaveSilence, WaveSine, WaveNoise:
+	default:
+		return nil, fmt.Errorf("audio: unknown waveform %q", waveform)
+	}
+	if waveform == WaveSine && (frequency <= 0 || frequency >= float64(sampleRate)/2) {
+		return nil, fmt.Errorf("audio: tone frequency %.1f Hz out of range", frequency)
+	}
+	return &Generator{
+		sampleRate: sampleRate,
+		channels:   channels,
+		waveform:   waveform,
+		frequency:  frequency,
+		amplitude:  float32(DBToAmplitude(level)),
+		pacer:      pacer{sampleRate: sampleRate},
+	}, nil
+}
+
+// Open resets the oscillator phase and real-time clock.
+func (g *Generator) Open() error {
+	g.phase = 0
+	g.pacer.reset()
+	return nil
+}
+
+// ReadFrames synthesizes len(buf)/Channels frames, pacing reads to the wall clock.
+func (g *Generator) ReadFrames(buf []float32) (int, error) {
+	frames := len(buf) / g.channels
+	g.fill(buf[:frames*g.channels], g.waveform, g.amplitude)
+	g.pacer.wait(frames)
+	return frames, nil
+}
+
+// fill writes the given waveform into buf without pacing.
+func (g *Generator) fill(buf []float32, waveform Waveform, amplitude float32) {
+	step := 2 * math.Pi * g.frequency / float64(g.sampleRate)
+	for i := 0; i < len(buf); i += g.channels {
+		var v float32
+		switch waveform {
+		case WaveSine:
+			v = amplitude * float32(math.Sin(g.phase))
+			g.phase = math.Mod(g.phase+step, 2*math.Pi)
+		case WaveNoise:
+			v = amplitude * (2*rand.Float32() - 1)
+		}
+		for c := 0; c < g.channels; c++ {
+			buf[i+c] = v
+		}
+	}
+}
+
+// SampleRate returns the sample rate in Hz.
+func (g *Generator) SampleRate() int { return g.sampleRate }
+
+// Channels returns the number of interleaved channels.
+func (g *Generator) Channels() int { return g.channels }
+
+// Close is a no-op.
+func (g *Generator) Close() error { return nil }
+
+// DBToAmplitude converts a level in dBFS to a linear amplitude.
+func DBToAmplitude(db float64) float64 {
+	return math.Pow(10, db/20)
+}
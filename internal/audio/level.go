@@ -0,0 +1,39 @@
+package audio
+
+import "math"
+
+// SilenceFloorDB is the level reported for digital silence instead of -Inf.
+const SilenceFloorDB = -120.0
+
+// ChannelLevels computes the linear RMS and absolute peak of each channel of
+// an interleaved block. rms and peak must have at least channels elements.
+func ChannelLevels(samples []float32, channels int, rms, peak []float64) {
+	for c := 0; c < channels; c++ {
+		rms[c], peak[c] = 0, 0
+	}
+	frames := len(samples) / channels
+	if frames == 0 {
+		return
+	}
+
+	for i := 0; i < frames*channels; i += channels {
+		for c := 0; c < channels; c++ {
+			v := float64(samples[i+c])
+			rms[c] += v * v
+			if a := math.Abs(v); a > peak[c] {
+				peak[c] = a
+			}
+		}
+	}
+	for c := 0; c < channels; c++ {
+		rms[c] = math.Sqrt(rms[c] / float64(frames))
+	}
+}
+
+// AmplitudeToDB converts a linear amplitude to dBFS, clamped at SilenceFloorDB.
+func AmplitudeToDB(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return SilenceFloorDB
+	}
+	return math.Max(20*math.Log10(amplitude), SilenceFloorDB)
+}
@@ -0,0 +1,73 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// Meter measures the peak and RMS level of each channel over consecutive
+// intervals, for display on level meters, and holds the highest peak for a
+// while so short transients stay visible. Like the VAD it counts frames
+// rather than reading the wall clock.
+type Meter struct {
+	channels       int
+	intervalFrames int
+	holdFrames     int
+
+	frames int // frames in the current interval
+	peak   []float64
+	sumSq  []float64
+
+	hold    []float64 // linear peak being held
+	holdAge []int     // frames since the held peak was set
+
+	peakDB, rmsDB, holdDB []float64
+}
+
+// NewMeter creates a meter that completes a reading every interval and holds
+// peaks for hold.
+func NewMeter(sampleRate, channels int, interval, hold time.Duration) *Meter {
+	return &Meter{
+		channels:       channels,
+		intervalFrames: max(int(durationToFrames(interval, sampleRate)), 1),
+		holdFrames:     int(durationToFrames(hold, sampleRate)),
+		peak:           make([]float64, channels),
+		sumSq:          make([]float64, channels),
+		hold:           make([]float64, channels),
+		holdAge:        make([]int, channels),
+		peakDB:         make([]float64, channels),
+		rmsDB:          make([]float64, channels),
+		holdDB:         make([]float64, channels),
+	}
+}
+
+// Write adds an interleaved block and calls emit with the levels in dBFS of
+// every interval it completes. The slices passed to emit are reused.
+func (m *Meter) Write(samples []float32, emit func(peak, rms, hold []float64)) {
+	for i := 0; i+m.channels <= len(samples); i += m.channels {
+		for c := range m.channels {
+			v := float64(samples[i+c])
+			m.sumSq[c] += v * v
+			m.peak[c] = max(m.peak[c], math.Abs(v))
+		}
+		if m.frames++; m.frames == m.intervalFrames {
+			m.complete()
+			emit(m.peakDB, m.rmsDB, m.holdDB)
+		}
+	}
+}
+
+// complete converts the finished interval to dBFS and starts the next one.
+func (m *Meter) complete() {
+	for c := range m.channels {
+		m.holdAge[c] += m.frames
+		if m.peak[c] >= m.hold[c] || m.holdAge[c] > m.holdFrames {
+			m.hold[c], m.holdAge[c] = m.peak[c], 0
+		}
+		m.peakDB[c] = AmplitudeToDB(m.peak[c])
+		m.rmsDB[c] = AmplitudeToDB(math.Sqrt(m.sumSq[c] / float64(m.frames)))
+		m.holdDB[c] = AmplitudeToDB(m.hold[c])
+		m.peak[c], m.sumSq[c] = 0, 0
+	}
+	m.frames = 0
+}
@@ -0,0 +1,113 @@
+package audio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scriptStep is one segment of a Script.
+type scriptStep struct {
+	waveform  Waveform
+	frames    int64
+	amplitude float32
+}
+
+// Script is a Source that plays a looping sequence of silence, tone and noise
+// segments, e.g. to exercise voice activity detection without a microphone.
+//
+// A script is a comma-separated list of "<waveform> <duration> [level dBFS]"
+// steps, such as "silence 10s, noise 3s -12, silence 5s, sine 2s". Steps
+// without a level use the default level given to NewScript.
+type Script struct {
+	gen   *Generator
+	steps []scriptStep
+	step  int
+	pos   int64 // frames played in the current step
+}
+
+// NewScript parses script and creates a Source for it. frequency is used by
+// sine steps.
+func NewScript(sampleRate, channels int, script string, frequency, level float64) (*Script, error) {
+	gen, err := NewGenerator(sampleRate, channels, WaveSine, frequency, level)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []scriptStep
+	for _, part := range strings.Split(script, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("audio: script step %q has too many fields", part)
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("audio: script step %q needs a waveform and duration", part)
+		}
+
+		waveform := Waveform(fields[0])
+		switch waveform {
+		case WaveSilence, WaveSine, WaveNoise:
+		default:
+			return nil, fmt.Errorf("audio: unknown waveform %q in script", fields[0])
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("audio: invalid duration %q in script", fields[1])
+		}
+		stepLevel := level
+		if len(fields) == 3 {
+			if stepLevel, err = strconv.ParseFloat(strings.TrimSuffix(fields[2], "dB"), 64); err != nil {
+				return nil, fmt.Errorf("audio: invalid level %q in script", fields[2])
+			}
+		}
+
+		steps = append(steps, scriptStep{
+			waveform:  waveform,
+			frames:    int64(d.Seconds() * float64(sampleRate)),
+			amplitude: float32(DBToAmplitude(stepLevel)),
+		})
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("audio: script is empty")
+	}
+	return &Script{gen: gen, steps: steps}, nil
+}
+
+// Open rewinds the script to its first step.
+func (s *Script) Open() error {
+	s.step, s.pos = 0, 0
+	return s.gen.Open()
+}
+
+// ReadFrames plays the script into buf, crossing step boundaries as needed.
+func (s *Script) ReadFrames(buf []float32) (int, error) {
+	channels := s.gen.channels
+	frames := len(buf) / channels
+
+	for done := 0; done < frames; {
+		st := s.steps[s.step]
+		n := int(min(int64(frames-done), st.frames-s.pos))
+		s.gen.fill(buf[done*channels:(done+n)*channels], st.waveform, st.amplitude)
+		done += n
+		s.pos += int64(n)
+		if s.pos >= st.frames {
+			s.step = (s.step + 1) % len(s.steps)
+			s.pos = 0
+		}
+	}
+	s.gen.pacer.wait(frames)
+	return frames, nil
+}
+
+// SampleRate returns the sample rate in Hz.
+func (s *Script) SampleRate() int { return s.gen.sampleRate }
+
+// Channels returns the number of interleaved channels.
+func (s *Script) Channels() int { return s.gen.channels }
+
+// Close is a no-op.
+func (s *Script) Close() error { return nil }
@@ -0,0 +1,74 @@
+package audio
+
+import "time"
+
+// Source is a stream of interleaved PCM frames consumed by the control manager.
+// Samples are 32-bit floats normalized to [-1, 1].
+type Source interface {
+	// Open prepares the source for reading. It must be called before ReadFrames.
+	Open() error
+	// ReadFrames fills buf with interleaved samples and returns the number of
+	// frames read. len(buf) must be a multiple of Channels(). It blocks until
+	// audio is available.
+	ReadFrames(buf []float32) (int, error)
+	SampleRate() int
+	Channels() int
+	Close() error
+}
+
+// pacer throttles synthetic sources so they deliver frames at the rate a
+// capture device would.
+type pacer struct {
+	sampleRate int
+	next       time.Time
+}
+
+// reset restarts the clock from now.
+func (p *pacer) reset() { p.next = time.Time{} }
+
+// wait blocks until the given number of frames would have been captured in real time.
+func (p *pacer) wait(frames int) {
+	now := time.Now()
+	if p.next.IsZero() {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(frames) * time.Second / time.Duration(p.sampleRate))
+	if d := p.next.Sub(now); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Silence is a Source that produces digital silence in real time.
+type Silence struct {
+	sampleRate int
+	channels   int
+	pacer      pacer
+}
+
+// NewSilence creates a silent Source with the given format.
+func NewSilence(sampleRate, channels int) *Silence {
+	return &Silence{sampleRate: sampleRate, channels: channels, pacer: pacer{sampleRate: sampleRate}}
+}
+
+// Open resets the real-time clock.
+func (s *Silence) Open() error {
+	s.pacer.reset()
+	return nil
+}
+
+// ReadFrames fills buf with zeros, pacing reads to the wall clock.
+func (s *Silence) ReadFrames(buf []float32) (int, error) {
+	frames := len(buf) / s.channels
+	clear(buf[:frames*s.channels])
+	s.pacer.wait(frames)
+	return frames, nil
+}
+
+// SampleRate returns the sample rate in Hz.
+func (s *Silence) SampleRate() int { return s.sampleRate }
+
+// Channels returns the number of interleaved channels.
+func (s *Silence) Channels() int { return s.channels }
+
+// Close is a no-op.
+func (s *Silence) Close() error { return nil }
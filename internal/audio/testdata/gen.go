@@ -0,0 +1,74 @@
+//go:build ignore
+
+// gen writes the PCM fixtures used by the activity detector tests. They are
+// 8 kHz mono 16-bit WAV files over a noise floor at -60 dBFS RMS; run it from
+// this directory with go run gen.go to regenerate them.
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand"
+
+	"nixon/internal/wav"
+)
+
+const sampleRate = 8000
+
+// segment is a stretch of fixture audio: a 440 Hz tone at level dBFS RMS, or
+// only the noise floor if level is 0.
+type segment struct {
+	secs  float64
+	level float64
+}
+
+func main() {
+	// burst: one second of floor, two seconds of syllables of 150 ms at
+	// -20 dBFS with 50 ms gaps, then two seconds of floor.
+	burst := []segment{{1, 0}}
+	for i := 0; i < 10; i++ {
+		burst = append(burst, segment{0.15, -20}, segment{0.05, 0})
+	}
+	burst = append(burst, segment{2, 0})
+	write("burst.wav", burst)
+
+	// hover: a tone at -30 dBFS, then two seconds alternating every 100 ms
+	// between -37 and -43 dBFS around a -40 dBFS threshold.
+	hover := []segment{{0.5, 0}, {1, -30}}
+	for i := 0; i < 10; i++ {
+		hover = append(hover, segment{0.1, -37}, segment{0.1, -43})
+	}
+	hover = append(hover, segment{0.5, 0})
+	write("hover.wav", hover)
+
+	// click: a single 10 ms click at -6 dBFS one second in.
+	write("click.wav", []segment{{1, 0}, {0.01, -6}, {0.99, 0}})
+}
+
+func write(name string, segments []segment) {
+	w, err := wav.Create(name, sampleRate, 1, 16)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	floor := math.Pow(10, -60.0/20) * math.Sqrt(3) // uniform noise at -60 dBFS RMS
+	var n int
+	for _, seg := range segments {
+		amp := 0.0
+		if seg.level != 0 {
+			amp = math.Pow(10, seg.level/20) * math.Sqrt2
+		}
+		samples := make([]float32, int(math.Round(seg.secs*sampleRate)))
+		for i := range samples {
+			tone := amp * math.Sin(2*math.Pi*440*float64(n)/sampleRate)
+			samples[i] = float32(tone + floor*(2*rng.Float64()-1))
+			n++
+		}
+		if err := w.WriteFrames(samples); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
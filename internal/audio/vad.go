@@ -0,0 +1,129 @@
+package audio
+
+import (
+	"fmt"
+	"time"
+)
+
+// VADMode selects which level measurement drives the detector.
+type VADMode string
+
+const (
+	VADModeRMS  VADMode = "rms"
+	VADModePeak VADMode = "peak"
+)
+
+// VADConfig configures a VAD.
+type VADConfig struct {
+	SampleRate int
+	Channels   int
+	Mode       VADMode
+	// ThresholdDB is the level in dBFS that must be exceeded to become active.
+	ThresholdDB float64
+	// HysteresisDB lowers the threshold used to detect silence once active,
+	// so a signal hovering around the threshold does not chatter.
+	HysteresisDB float64
+	// Attack is how long the level must stay above the threshold to activate.
+	Attack time.Duration
+	// Release is how long the level must stay below the release threshold to deactivate.
+	Release time.Duration
+}
+
+// VAD is an energy-based activity detector. It measures each block in dBFS
+// and switches between active and inactive with attack and release hysteresis.
+//
+// All timing is derived from the number of frames processed rather than the
+// wall clock, so the detector behaves identically on live audio and on
+// recorded fixtures.
+type VAD struct {
+	cfg           VADConfig
+	attackFrames  int64
+	releaseFrames int64
+
+	active bool
+	above  int64 // consecutive frames above the threshold while inactive
+	below  int64 // consecutive frames below the release threshold while active
+
+	rms, peak     []float64
+	rmsDB, peakDB float64
+}
+
+// NewVAD creates a detector in the inactive state.
+func NewVAD(cfg VADConfig) (*VAD, error) {
+	if cfg.SampleRate <= 0 || cfg.Channels <= 0 {
+		return nil, fmt.Errorf("audio: invalid VAD format %d Hz / %d ch", cfg.SampleRate, cfg.Channels)
+	}
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = VADModeRMS
+	case VADModeRMS, VADModePeak:
+	default:
+		return nil, fmt.Errorf("audio: unknown VAD mode %q", cfg.Mode)
+	}
+	if cfg.HysteresisDB < 0 {
+		return nil, fmt.Errorf("audio: VAD hysteresis must not be negative")
+	}
+	return &VAD{
+		cfg:           cfg,
+		attackFrames:  durationToFrames(cfg.Attack, cfg.SampleRate),
+		releaseFrames: durationToFrames(cfg.Release, cfg.SampleRate),
+		rms:           make([]float64, cfg.Channels),
+		peak:          make([]float64, cfg.Channels),
+		rmsDB:         SilenceFloorDB,
+		peakDB:        SilenceFloorDB,
+	}, nil
+}
+
+func durationToFrames(d time.Duration, sampleRate int) int64 {
+	return int64(d.Seconds() * float64(sampleRate))
+}
+
+// Process analyses a block of interleaved samples and reports whether the
+// detector changed state as a result.
+func (v *VAD) Process(samples []float32) bool {
+	frames := int64(len(samples) / v.cfg.Channels)
+	ChannelLevels(samples, v.cfg.Channels, v.rms, v.peak)
+
+	var rms, peak float64
+	for c := range v.rms {
+		rms = max(rms, v.rms[c])
+		peak = max(peak, v.peak[c])
+	}
+	v.rmsDB, v.peakDB = AmplitudeToDB(rms), AmplitudeToDB(peak)
+
+	level := v.rmsDB
+	if v.cfg.Mode == VADModePeak {
+		level = v.peakDB
+	}
+
+	if !v.active {
+		if level < v.cfg.ThresholdDB {
+			v.above = 0
+			return false
+		}
+		v.above += frames
+		if v.above < v.attackFrames {
+			return false
+		}
+		v.active, v.above, v.below = true, 0, 0
+		return true
+	}
+
+	if level >= v.cfg.ThresholdDB-v.cfg.HysteresisDB {
+		v.below = 0
+		return false
+	}
+	v.below += frames
+	if v.below < v.releaseFrames {
+		return false
+	}
+	v.active, v.above, v.below = false, 0, 0
+	return true
+}
+
+// Active reports whether activity is currently detected.
+func (v *VAD) Active() bool { return v.active }
+
+// Level returns the RMS and peak level in dBFS of the loudest channel in the
+// last processed block.
+func (v *VAD) Level() (rmsDB, peakDB float64) { return v.rmsDB, v.peakDB }
@@ -0,0 +1,151 @@
+package audio
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nixon/internal/wav"
+)
+
+// fixtureBlock is the block size fixtures are fed to the detector in, the
+// same as the capture loop reads.
+const fixtureBlock = 20 * time.Millisecond
+
+// transition is a change of detector state, timed at the end of the block
+// that caused it.
+type transition struct {
+	at     time.Duration
+	active bool
+}
+
+// runFixture feeds a fixture from testdata to a detector with cfg and returns
+// the transitions it made. The format comes from the fixture.
+func runFixture(t *testing.T, name string, cfg VADConfig) []transition {
+	t.Helper()
+	r, err := wav.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("opening fixture: %v", err)
+	}
+	defer r.Close()
+	info := r.Info()
+	cfg.SampleRate, cfg.Channels = info.SampleRate, info.Channels
+	vad, err := NewVAD(cfg)
+	if err != nil {
+		t.Fatalf("NewVAD: %v", err)
+	}
+
+	var got []transition
+	var frames int64
+	buf := make([]float32, info.SampleRate*int(fixtureBlock)/int(time.Second)*info.Channels)
+	for {
+		n, err := r.ReadFrames(buf)
+		if err != nil {
+			break
+		}
+		frames += int64(n)
+		if vad.Process(buf[:n*info.Channels]) {
+			at := time.Duration(frames) * time.Second / time.Duration(info.SampleRate)
+			got = append(got, transition{at, vad.Active()})
+		}
+	}
+	return got
+}
+
+// checkTransitions compares transitions allowing each to be one block off,
+// since fixture segments need not start on a block boundary.
+func checkTransitions(t *testing.T, got, want []transition) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d transitions %v, want %v", len(got), got, want)
+	}
+	for i := range got {
+		d := got[i].at - want[i].at
+		if got[i].active != want[i].active || d > fixtureBlock || d < -fixtureBlock {
+			t.Fatalf("transition %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func ms(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+
+func TestVADFixtures(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		cfg     VADConfig
+		want    []transition
+	}{
+		{
+			// The 50 ms gaps between syllables are bridged by the release time,
+			// which only runs out once the bursts are over.
+			name:    "attack then release after grace",
+			fixture: "burst.wav",
+			cfg:     VADConfig{Mode: VADModeRMS, ThresholdDB: -40, Attack: ms(100), Release: ms(500)},
+			want:    []transition{{ms(1100), true}, {ms(3450), false}},
+		},
+		{
+			// No 150 ms syllable lasts as long as the attack time.
+			name:    "bursts shorter than attack",
+			fixture: "burst.wav",
+			cfg:     VADConfig{Mode: VADModeRMS, ThresholdDB: -40, Attack: ms(200), Release: ms(500)},
+		},
+		{
+			name:    "threshold above signal",
+			fixture: "burst.wav",
+			cfg:     VADConfig{Mode: VADModeRMS, ThresholdDB: -10, Attack: ms(20), Release: ms(500)},
+		},
+		{
+			// Dips to -43 dBFS stay above the release threshold of -46 dBFS.
+			name:    "hysteresis holds a hovering level",
+			fixture: "hover.wav",
+			cfg:     VADConfig{Mode: VADModeRMS, ThresholdDB: -40, HysteresisDB: 6, Attack: ms(50), Release: ms(300)},
+			want:    []transition{{ms(550), true}, {ms(3800), false}},
+		},
+		{
+			name:    "peak mode catches a click",
+			fixture: "click.wav",
+			cfg:     VADConfig{Mode: VADModePeak, ThresholdDB: -20, Release: ms(500)},
+			want:    []transition{{ms(1020), true}, {ms(1520), false}},
+		},
+		{
+			name:    "attack ignores a click",
+			fixture: "click.wav",
+			cfg:     VADConfig{Mode: VADModePeak, ThresholdDB: -20, Attack: ms(50), Release: ms(500)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkTransitions(t, runFixture(t, tt.fixture, tt.cfg), tt.want)
+		})
+	}
+}
+
+func TestVADChattersWithoutHysteresis(t *testing.T) {
+	got := runFixture(t, "hover.wav", VADConfig{Mode: VADModeRMS, ThresholdDB: -40, Attack: ms(50), Release: ms(50)})
+
+	// Activation on the -30 dBFS tone, then a release in each of the ten
+	// dips to -43 dBFS and a new attack in each of the nine rises after them.
+	if len(got) != 20 {
+		t.Fatalf("got %d transitions %v, want 20", len(got), got)
+	}
+	for i, tr := range got {
+		if tr.active != (i%2 == 0) {
+			t.Fatalf("transition %d = %+v, want alternating states", i, tr)
+		}
+	}
+}
+
+func TestVADRejectsInvalidConfig(t *testing.T) {
+	tests := []VADConfig{
+		{SampleRate: 0, Channels: 1},
+		{SampleRate: 8000, Channels: 0},
+		{SampleRate: 8000, Channels: 1, Mode: "loudness"},
+		{SampleRate: 8000, Channels: 1, HysteresisDB: -1},
+	}
+	for _, cfg := range tests {
+		if _, err := NewVAD(cfg); err == nil {
+			t.Errorf("NewVAD(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
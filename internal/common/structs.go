@@ -1,22 +1,37 @@
 package common
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // AudioState represents the high-level state of the audio manager
 type AudioState string
 
 // Defines the possible states of the audio manager
 const (
-	StateStopped   AudioState = "stopped"
-	StateRecording AudioState = "recording"
-	StateStreaming AudioState = "streaming" // Example future state
+	StateStopped    AudioState = "stopped"
+	StateArmed      AudioState = "armed" // waiting for the activity detector to trigger
+	StateRecording  AudioState = "recording"
+	StatePaused     AudioState = "paused"
+	StateFinalizing AudioState = "finalizing" // closing the file after a take
+	StateStreaming  AudioState = "streaming"  // Example future state
 )
 
+// StateTransition records a single change of the audio manager's state.
+type StateTransition struct {
+	From  AudioState `json:"from"`
+	To    AudioState `json:"to"`
+	Event string     `json:"event"`
+	Time  time.Time  `json:"time"`
+}
+
 // AudioStatus defines the real-time status of the audio manager
 type AudioStatus struct {
 	State          AudioState `json:"state,omitempty"`
 	CurrentRecFile string     `json:"currentRecFile,omitempty"`
 	IsAutoRec      bool       `json:"isAutoRec,omitempty"`
+	AudioError     string     `json:"audioError,omitempty"` // Why the audio engine last stopped on its own, if it failed
 
 	// --- Fields required by pipewire.go ---
 	ActiveStreams map[string]bool `json:"activeStreams,omitempty"`
@@ -25,6 +40,40 @@ type AudioStatus struct {
 	LastVADEvent  time.Time       `json:"lastVadEvent,omitempty"` // Last time VAD triggered
 }
 
+// MeterUpdate is a reading of the live input levels, pushed to clients that
+// subscribe to meters.
+type MeterUpdate struct {
+	Time     time.Time      `json:"time"`
+	Channels []ChannelLevel `json:"channels"`
+}
+
+// ChannelLevel holds the levels of one channel in dBFS.
+type ChannelLevel struct {
+	Peak     float64 `json:"peak"`
+	RMS      float64 `json:"rms"`
+	PeakHold float64 `json:"peakHold"` // highest recent peak
+}
+
+// WSMessage is the envelope of every WebSocket message. Clients send
+// commands with a Type, an optional Payload and an ID of their choosing; the
+// reply carries the same ID and Type with either the result in Payload or an
+// Error. Pushed events such as status_update have no ID.
+type WSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   *WSError        `json:"error,omitempty"`
+}
+
+// WSError reports why a WebSocket command failed. Code is the HTTP status the
+// same request gets from the REST API.
+type WSError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *WSError) Error() string { return e.Message }
+
 // AudioDevice represents a single discoverable audio device
 type AudioDevice struct {
 	DeviceName  string `json:"deviceName,omitempty"`
@@ -49,10 +98,126 @@ type AudioCapabilities struct {
 type Recording struct {
 	ID        uint          `json:"id,omitempty" gorm:"primaryKey"`
 	Filename  string        `json:"filename,omitempty"`
-	StartTime time.Time     `json:"startTime,omitempty"`
+	StartTime time.Time     `json:"startTime,omitempty" gorm:"index"`
 	EndTime   time.Time     `json:"endTime,omitempty"`
 	Duration  time.Duration `json:"duration,omitempty"`
 	FileSize  int64         `json:"fileSize,omitempty"`
+	Format    string        `json:"format,omitempty" gorm:"default:wav"` // container of the audio file, FormatWAV or FormatFLAC
 	Notes     string        `json:"notes,omitempty"`
 	Genre     string        `json:"genre,omitempty"`
+	PreRoll   time.Duration `json:"preRoll,omitempty"`  // audio captured before the trigger
+	PostRoll  time.Duration `json:"postRoll,omitempty"` // audio kept after activity ended
+	Favorite  bool          `json:"favorite,omitempty" gorm:"index"`
+	Rating    int           `json:"rating,omitempty"` // 1-5, or 0 if unrated
+	Tags      []Tag         `json:"tags,omitempty" gorm:"many2many:recording_tags"`
+
+	// Takes longer than AutoRecord.MaxRecordMins are split into consecutive
+	// parts. SessionID is the ID of the first part, or 0 on the first part itself.
+	SessionID uint `json:"sessionId,omitempty" gorm:"index"`
+	Part      int  `json:"part,omitempty"`
+
+	// EBU R128 measurements of the finished take, nil until it is analyzed.
+	Loudness      *float64 `json:"loudness,omitempty" gorm:"index"` // integrated, in LUFS
+	LoudnessRange *float64 `json:"loudnessRange,omitempty"`         // in LU
+	SamplePeak    *float64 `json:"samplePeak,omitempty"`            // in dBFS
+	TruePeak      *float64 `json:"truePeak,omitempty" gorm:"index"` // in dBTP
+}
+
+// Recording container formats.
+const (
+	FormatWAV  = "wav"
+	FormatFLAC = "flac"
+)
+
+// RecordingUpdate is a partial update of a recording's user-editable
+// metadata. Nil fields are left unchanged.
+type RecordingUpdate struct {
+	Notes    *string   `json:"notes" validate:"omitempty,max=4000"`
+	Genre    *string   `json:"genre" validate:"omitempty,max=64"`
+	Favorite *bool     `json:"favorite"`
+	Rating   *int      `json:"rating" validate:"omitempty,min=0,max=5"`
+	Tags     *[]string `json:"tags" validate:"omitempty,max=64,dive,min=1,max=32"` // replaces the whole set
+}
+
+// Tag is a label attached to any number of recordings. Names are stored
+// trimmed and lower-case.
+type Tag struct {
+	ID    uint   `json:"-" gorm:"primaryKey"`
+	Name  string `json:"name" gorm:"uniqueIndex;not null"`
+	Count int64  `json:"count,omitempty" gorm:"->;-:migration"` // recordings with this tag, when listing tags
+}
+
+// RecordingQuery filters, orders and pages a list of recordings. Zero-valued
+// fields do not filter.
+type RecordingQuery struct {
+	Session     uint      // parts of the session started by this recording
+	From        time.Time // StartTime at or after
+	To          time.Time // StartTime before
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	Genre       string   // exact genre, ignoring case
+	Search      string   // text contained in the notes or genre
+	Tags        []string // tags the recording must all have
+	Favorite    bool     // favorites only
+	MinRating   int      `validate:"min=0,max=5"`
+	MinLoudness *float64 // bounds on the integrated loudness in LUFS; takes not yet analyzed never match
+	MaxLoudness *float64
+	MinTruePeak *float64 // bounds on the true peak in dBTP
+	MaxTruePeak *float64
+	Sort        string `validate:"omitempty,oneof=id startTime duration fileSize filename genre part rating loudness loudnessRange samplePeak truePeak"`
+	Desc        bool
+	Limit       int `validate:"min=0,max=1000"` // 0 means the largest page, 1000
+	Offset      int `validate:"min=0"`
+}
+
+// Marker is a labelled position inside a recording, or a region when End is
+// set. Offsets are measured from the first sample of the file.
+type Marker struct {
+	ID          uint          `json:"id" gorm:"primaryKey"`
+	RecordingID uint          `json:"recordingId" gorm:"index;not null"`
+	Offset      time.Duration `json:"offset"`
+	End         time.Duration `json:"end,omitempty"` // end of a region, 0 for a point marker
+	Label       string        `json:"label,omitempty"`
+	Color       string        `json:"color,omitempty"` // CSS hex color such as "#f80"
+	CreatedAt   time.Time     `json:"createdAt"`
+}
+
+// MarkerUpdate is a partial update of a marker. Nil fields are left unchanged.
+type MarkerUpdate struct {
+	Offset *time.Duration `json:"offset" validate:"omitempty,min=0"`
+	End    *time.Duration `json:"end" validate:"omitempty,min=0"`
+	Label  *string        `json:"label" validate:"omitempty,max=256"`
+	Color  *string        `json:"color" validate:"omitempty,hexcolor"`
+}
+
+// JobStatus is the lifecycle state of a background job.
+type JobStatus string
+
+// Defines the possible states of a job
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// ExportJob converts a recording into a compressed deliverable in the background.
+type ExportJob struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	RecordingID uint      `json:"recordingId" gorm:"index;not null"`
+	Format      string    `json:"format"`
+	Bitrate     int       `json:"bitrate,omitempty"` // kbit/s for lossy formats, 0 for the encoder's default
+	Status      JobStatus `json:"status" gorm:"index"`
+	Progress    float64   `json:"progress"` // 0 to 1
+	Error       string    `json:"error,omitempty"`
+	Filename    string    `json:"filename,omitempty"` // in the exports directory, once done
+	FileSize    int64     `json:"fileSize,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	FinishedAt  time.Time `json:"finishedAt,omitzero"`
+}
+
+// ExportRequest asks for a recording to be exported.
+type ExportRequest struct {
+	Format  string `json:"format" validate:"required,max=16"`
+	Bitrate int    `json:"bitrate" validate:"omitempty,min=16,max=512"`
 }
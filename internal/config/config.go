@@ -16,27 +16,63 @@ type Config struct {
 	SRT      SrtSettings      `mapstructure:"srt"`
 	Database DatabaseSettings `mapstructure:"database"`
 	Pipewire PipewireSettings `mapstructure:"pipewire"`
+	Export   ExportSettings   `mapstructure:"export"`
 }
 
 // WebSettings configures the web server
 type WebSettings struct {
-	ListenAddress string `mapstructure:"listenAddress"`
-	Secret        string `mapstructure:"secret"`
-	WebDevServerURL string `mapstructure:"webDevServerURL"` // ADDED: URL for the Vite development server
+	ListenAddress   string            `mapstructure:"listenAddress"`
+	Secret          string            `mapstructure:"secret"`
+	WebDevServerURL string            `mapstructure:"webDevServerURL"` // ADDED: URL for the Vite development server
+	WebSocket       WebSocketSettings `mapstructure:"websocket"`
+}
+
+// WebSocketSettings configures the /ws connections. Clients that stop
+// answering pings, such as phones that went to sleep, are disconnected.
+type WebSocketSettings struct {
+	PingIntervalSecs int   `mapstructure:"pingIntervalSecs"` // how often clients are pinged
+	PongTimeoutSecs  int   `mapstructure:"pongTimeoutSecs"`  // silence after which a client is considered gone
+	WriteTimeoutSecs int   `mapstructure:"writeTimeoutSecs"` // longest a single message may take to send
+	MaxMessageBytes  int64 `mapstructure:"maxMessageBytes"`  // largest message accepted from a client
 }
 
 // AudioSettings configures the audio processing
 type AudioSettings struct {
-	DeviceName string `mapstructure:"deviceName"`
-	SampleRate int    `mapstructure:"sampleRate"`
+	DeviceName    string         `mapstructure:"deviceName"`
+	SampleRate    int            `mapstructure:"sampleRate"`
+	Channels      int            `mapstructure:"channels"`
+	RecordingsDir string         `mapstructure:"recordingsDir"`
+	Source        SourceSettings `mapstructure:"source"`
+	// Format is the container of new recordings: wav or flac.
+	Format           string `mapstructure:"format"`
+	BitDepth         int    `mapstructure:"bitDepth"`         // 16 or 24
+	CompressionLevel int    `mapstructure:"compressionLevel"` // FLAC only, 0 (fastest) to 8 (smallest)
+	MeterRate        int    `mapstructure:"meterRate"`        // level meter readings per second, 1-60
+	MeterHoldMs      int    `mapstructure:"meterHoldMs"`      // how long meters hold their highest peak
+}
+
+// SourceSettings selects where captured audio comes from. The synthetic
+// types allow running the recorder without an audio interface attached.
+type SourceSettings struct {
+	Type      string  `mapstructure:"type"` // silence, tone, noise, file or script
+	File      string  `mapstructure:"file"`
+	Loop      bool    `mapstructure:"loop"`
+	Frequency float64 `mapstructure:"frequency"` // tone frequency in Hz
+	Level     float64 `mapstructure:"level"`     // generator level in dBFS
+	Script    string  `mapstructure:"script"`
 }
 
 // AutoRecord configures the automatic recording feature
 type AutoRecord struct {
 	Enabled       bool    `mapstructure:"enabled"`
-	VADThreshold  float64 `mapstructure:"vadThreshold"`
-	VADGraceTime  int     `mapstructure:"vadGraceTime"`
+	VADThreshold  float64 `mapstructure:"vadThreshold"`  // trigger level in dBFS; positive values are read as linear amplitude
+	VADGraceTime  int     `mapstructure:"vadGraceTime"`  // seconds of silence before stopping
+	VADMode       string  `mapstructure:"vadMode"`       // rms or peak
+	VADAttackMs   int     `mapstructure:"vadAttackMs"`   // time above threshold before triggering
+	VADHysteresis float64 `mapstructure:"vadHysteresis"` // dB below the threshold that counts as silence
 	MaxRecordMins int     `mapstructure:"maxRecordMins"`
+	PreRollSecs   int     `mapstructure:"preRollSecs"`  // audio kept from before the trigger, 0-30
+	PostRollSecs  int     `mapstructure:"postRollSecs"` // audio kept after the grace time expires
 }
 
 // IcecastSettings configures the Icecast output
@@ -73,6 +109,12 @@ type PipewireSettings struct {
 	Socket string `mapstructure:"socket"`
 }
 
+// ExportSettings configures exports of recordings to compressed formats
+type ExportSettings struct {
+	Dir        string `mapstructure:"dir"`        // where exported files are kept
+	FFmpegPath string `mapstructure:"ffmpegPath"` // enables the opus and mp3 formats when found
+}
+
 var AppConfig Config
 
 // LoadConfig reads configuration from file or environment variables.
@@ -89,14 +131,37 @@ func LoadConfig() {
 	viper.SetDefault("database.path", "nixon.db")
 	viper.SetDefault("audio.deviceName", "default")
 	viper.SetDefault("audio.sampleRate", 48000)
+	viper.SetDefault("audio.channels", 2)
+	viper.SetDefault("audio.recordingsDir", "recordings")
+	viper.SetDefault("audio.format", "wav")
+	viper.SetDefault("audio.bitDepth", 24)
+	viper.SetDefault("audio.compressionLevel", 5)
+	viper.SetDefault("audio.meterRate", 25)
+	viper.SetDefault("audio.meterHoldMs", 1500)
+	viper.SetDefault("audio.source.type", "silence")
+	viper.SetDefault("audio.source.loop", true)
+	viper.SetDefault("audio.source.frequency", 440.0)
+	viper.SetDefault("audio.source.level", -18.0)
+	viper.SetDefault("audio.source.script", "silence 20s, noise 10s -12, silence 5s, sine 5s")
 	viper.SetDefault("autoRecord.enabled", false)
-	viper.SetDefault("autoRecord.vadThreshold", 0.7)
+	viper.SetDefault("autoRecord.vadThreshold", -45.0)
 	viper.SetDefault("autoRecord.vadGraceTime", 2)
+	viper.SetDefault("autoRecord.vadMode", "rms")
+	viper.SetDefault("autoRecord.vadAttackMs", 60)
+	viper.SetDefault("autoRecord.vadHysteresis", 6.0)
 	viper.SetDefault("autoRecord.maxRecordMins", 60)
+	viper.SetDefault("autoRecord.preRollSecs", 5)
+	viper.SetDefault("autoRecord.postRollSecs", 0)
 	viper.SetDefault("icecast.enabled", false)
 	viper.SetDefault("srt.enabled", false)
+	viper.SetDefault("export.dir", "exports")
+	viper.SetDefault("export.ffmpegPath", "ffmpeg")
 	viper.SetDefault("pipewire.socket", "") // Default socket lets the library auto-discover
 	viper.SetDefault("web.secret", "nixon-default-secret")
+	viper.SetDefault("web.websocket.pingIntervalSecs", 30)
+	viper.SetDefault("web.websocket.pongTimeoutSecs", 60)
+	viper.SetDefault("web.websocket.writeTimeoutSecs", 10)
+	viper.SetDefault("web.websocket.maxMessageBytes", 65536)
 	viper.SetDefault("web.webDevServerURL", "") // ADDED: Default empty, will be set by env for dev
 
 	err := viper.ReadInConfig()
@@ -0,0 +1,116 @@
+package control
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+
+	"nixon/internal/common"
+	"nixon/internal/slogger"
+	"nixon/internal/wav"
+)
+
+// originator identifies Nixon in the bext chunk of the files it records.
+const originator = "Nixon"
+
+// ixmlDocument is the iXML chunk of a recording. The standard fields carry
+// what DAWs display; the NIXON block carries the library metadata.
+type ixmlDocument struct {
+	XMLName xml.Name `xml:"BWFXML"`
+	Version string   `xml:"IXML_VERSION"`
+	Project string   `xml:"PROJECT"`
+	Tape    string   `xml:"TAPE,omitempty"`
+	Note    string   `xml:"NOTE,omitempty"`
+	FileUID string   `xml:"FILE_UID"`
+	Nixon   ixmlNixon
+	Speed   ixmlSpeed
+}
+
+type ixmlNixon struct {
+	XMLName     xml.Name `xml:"NIXON"`
+	RecordingID uint     `xml:"RECORDING_ID"`
+	SessionID   uint     `xml:"SESSION_ID,omitempty"`
+	Part        int      `xml:"PART,omitempty"`
+	Genre       string   `xml:"GENRE,omitempty"`
+	Favorite    bool     `xml:"FAVORITE,omitempty"`
+	Rating      int      `xml:"RATING,omitempty"`
+	Tags        *ixmlTags
+}
+
+type ixmlTags struct {
+	XMLName xml.Name `xml:"TAGS"`
+	Tag     []string `xml:"TAG"`
+}
+
+type ixmlSpeed struct {
+	XMLName                  xml.Name `xml:"SPEED"`
+	FileSampleRate           int      `xml:"FILE_SAMPLE_RATE"`
+	AudioBitDepth            int      `xml:"AUDIO_BIT_DEPTH"`
+	TimestampSamplesPerSec   int      `xml:"TIMESTAMP_SAMPLE_RATE"`
+	TimestampSinceMidnightLo uint32   `xml:"TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_LO"`
+	TimestampSinceMidnightHi uint32   `xml:"TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_HI"`
+}
+
+// metadataChunks builds the bext and iXML chunks describing rec.
+func metadataChunks(rec *common.Recording, sampleRate, channels, bitsPerSample int) []wav.Chunk {
+	hostname, _ := os.Hostname()
+	timeRef := wav.TimeReferenceAt(rec.StartTime, sampleRate)
+
+	description := rec.Notes
+	if description == "" {
+		description = rec.Filename
+	}
+	mode := "mono"
+	if channels == 2 {
+		mode = "stereo"
+	} else if channels > 2 {
+		mode = "multichannel"
+	}
+	bext := wav.Bext{
+		Description:         description,
+		Originator:          originator,
+		OriginatorReference: hostname,
+		Origination:         rec.StartTime,
+		TimeReference:       timeRef,
+		Loudness:            bextLoudness(rec),
+		CodingHistory:       fmt.Sprintf("A=PCM,F=%d,W=%d,M=%s,T=%s\r\n", sampleRate, bitsPerSample, mode, originator),
+	}
+
+	doc := ixmlDocument{
+		Version: "2.10",
+		Project: originator,
+		Tape:    hostname,
+		Note:    rec.Notes,
+		FileUID: fmt.Sprintf("NIXON-%s-%d", hostname, rec.ID),
+		Nixon: ixmlNixon{
+			RecordingID: rec.ID,
+			SessionID:   rec.SessionID,
+			Part:        rec.Part,
+			Genre:       rec.Genre,
+			Favorite:    rec.Favorite,
+			Rating:      rec.Rating,
+		},
+		Speed: ixmlSpeed{
+			FileSampleRate:           sampleRate,
+			AudioBitDepth:            bitsPerSample,
+			TimestampSamplesPerSec:   sampleRate,
+			TimestampSinceMidnightLo: uint32(timeRef),
+			TimestampSinceMidnightHi: uint32(timeRef >> 32),
+		},
+	}
+	if len(rec.Tags) > 0 {
+		doc.Nixon.Tags = &ixmlTags{}
+		for _, t := range rec.Tags {
+			doc.Nixon.Tags.Tag = append(doc.Nixon.Tags.Tag, t.Name)
+		}
+	}
+	ixml, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		slogger.Log.Error("Failed to encode iXML metadata", "err", err, "id", rec.ID)
+	}
+
+	return []wav.Chunk{
+		{ID: "bext", Data: bext.Encode()},
+		{ID: "iXML", Data: append([]byte(xml.Header), ixml...)},
+	}
+}
@@ -0,0 +1,208 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/db"
+	"nixon/internal/flac"
+	"nixon/internal/slogger"
+	"nixon/internal/wav"
+)
+
+// takeWriter encodes a take in its container format.
+type takeWriter interface {
+	WriteFrames(samples []float32) error
+	Sync() error
+	Close() error
+	Frames() int64
+	Size() int64
+	// AddCue and AddRegion embed markers, where the format can carry them.
+	AddCue(frame int64, label string)
+	AddRegion(frame, length int64, label string)
+	// setMetadata sets the metadata written when the file is closed.
+	setMetadata(rec *common.Recording)
+	// snapshot opens a complete file of the audio written so far.
+	snapshot(path string) (liveSnapshot, error)
+}
+
+// liveSnapshot is a read-only view of a take that is still being written.
+type liveSnapshot interface {
+	io.ReadSeekCloser
+	Size() int64
+}
+
+// createTake creates the file at path in the given format, using the
+// configured bit depth and compression level.
+func createTake(path, format string, sampleRate, channels int) (takeWriter, error) {
+	cfg := config.AppConfig.Audio
+	switch format {
+	case common.FormatWAV:
+		w, err := wav.Create(path, sampleRate, channels, cfg.BitDepth)
+		if err != nil {
+			return nil, err
+		}
+		return &wavTake{Writer: w, sampleRate: sampleRate, channels: channels, bitDepth: cfg.BitDepth}, nil
+	case common.FormatFLAC:
+		w, err := flac.Create(path, sampleRate, channels, cfg.BitDepth, cfg.CompressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		return &flacTake{Writer: w, sampleRate: sampleRate}, nil
+	}
+	return nil, fmt.Errorf("unknown recording format %q", format)
+}
+
+// maxFormatFrames returns the most frames a single file of the format can hold.
+func maxFormatFrames(format string, channels int) int64 {
+	if format == common.FormatFLAC {
+		return flac.MaxFrames()
+	}
+	return wav.MaxFrames(channels, config.AppConfig.Audio.BitDepth)
+}
+
+// wavTake writes a Broadcast Wave file with markers as cue points and regions.
+type wavTake struct {
+	*wav.Writer
+	sampleRate int
+	channels   int
+	bitDepth   int
+}
+
+func (t *wavTake) setMetadata(rec *common.Recording) {
+	for _, c := range metadataChunks(rec, t.sampleRate, t.channels, t.bitDepth) {
+		t.SetChunk(c.ID, c.Data)
+	}
+}
+
+func (t *wavTake) snapshot(path string) (liveSnapshot, error) {
+	return wav.OpenSnapshot(path, t.DataSize())
+}
+
+// flacTake writes a FLAC file with Vorbis comments. FLAC has no general
+// marker format, so markers are only kept in the database.
+type flacTake struct {
+	*flac.Writer
+	sampleRate int
+	markers    int // markers that could not be embedded
+}
+
+func (t *flacTake) AddCue(int64, string)           { t.markers++ }
+func (t *flacTake) AddRegion(int64, int64, string) { t.markers++ }
+
+func (t *flacTake) setMetadata(rec *common.Recording) {
+	t.SetComments(vorbisComments(rec, t.sampleRate))
+	if t.markers > 0 {
+		slogger.Log.Info("FLAC takes carry no embedded markers, keeping them in the database only", "file", rec.Filename, "markers", t.markers)
+	}
+}
+
+// snapshot covers only complete FLAC frames, so up to one block of the most
+// recent audio is left out.
+func (t *flacTake) snapshot(path string) (liveSnapshot, error) {
+	return flac.OpenSnapshot(path, t.Size(), t.EncodedFrames())
+}
+
+// repairTake fixes up a file left unfinished by a crash and returns its
+// length and size.
+func repairTake(path, format string) (bool, time.Duration, int64, error) {
+	if format == common.FormatFLAC {
+		repaired, err := flac.Repair(path)
+		if err != nil {
+			return false, 0, 0, err
+		}
+		info, err := flac.Stat(path)
+		return repaired, info.Duration, info.Size, err
+	}
+	repaired, err := wav.Repair(path)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	info, err := wav.Stat(path)
+	return repaired, info.Duration, info.Size, err
+}
+
+// audioReader decodes the frames of a recording file.
+type audioReader interface {
+	ReadFrames(buf []float32) (int, error)
+	Rewind()
+	Close() error
+}
+
+// audioInfo describes the audio of a recording file.
+type audioInfo struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	Frames        int64
+}
+
+// openRecordingAudio opens the file of a finished recording for decoding.
+func openRecordingAudio(rec *common.Recording) (audioReader, audioInfo, error) {
+	path := filepath.Join(config.AppConfig.Audio.RecordingsDir, rec.Filename)
+	if rec.Format == common.FormatFLAC {
+		r, err := flac.Open(path)
+		if err != nil {
+			return nil, audioInfo{}, err
+		}
+		i := r.Info()
+		return r, audioInfo{i.SampleRate, i.Channels, i.BitsPerSample, i.Frames}, nil
+	}
+	r, err := wav.Open(path)
+	if err != nil {
+		return nil, audioInfo{}, err
+	}
+	i := r.Info()
+	return r, audioInfo{i.SampleRate, i.Channels, i.BitsPerSample, i.Frames}, nil
+}
+
+// writeFileMetadata rewrites the embedded metadata of a finished recording,
+// and the markers of a WAV file, and stores the file's new size in rec and
+// the database. Rewrites are
+// serialized and each embeds the latest metadata in the database, so edits
+// racing each other can neither interleave in the file nor leave the older
+// one in it.
+func (m *Manager) writeFileMetadata(rec *common.Recording) error {
+	m.fileMux.Lock()
+	defer m.fileMux.Unlock()
+
+	latest, err := db.GetRecordingByID(rec.ID)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(config.AppConfig.Audio.RecordingsDir, latest.Filename)
+	switch latest.Format {
+	case common.FormatFLAC:
+		info, err := flac.Stat(path)
+		if err != nil {
+			return err
+		}
+		// The comments are rewritten in place, so the size does not change.
+		return flac.ReplaceComments(path, vorbisComments(latest, info.SampleRate))
+	default:
+		info, err := wav.Stat(path)
+		if err != nil {
+			return err
+		}
+		markers, err := db.GetMarkers(latest.ID)
+		if err != nil {
+			return err
+		}
+		chunks := metadataChunks(latest, info.SampleRate, info.Channels, info.BitsPerSample)
+		chunks = append(chunks, wav.CueChunks(markerCues(markers, info.SampleRate, info.Frames))...)
+		if err := wav.ReplaceChunks(path, chunks...); err != nil {
+			return err
+		}
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	rec.FileSize = st.Size()
+	return db.SetFileSize(rec.ID, rec.FileSize)
+}
@@ -0,0 +1,99 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nixon/internal/config"
+	"nixon/internal/db"
+)
+
+// audioContentTypes maps recording file extensions to their MIME types.
+var audioContentTypes = map[string]string{
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+}
+
+// RecordingAudio is an open recording file ready to be served over HTTP.
+type RecordingAudio struct {
+	io.ReadSeekCloser
+	Name        string
+	ContentType string
+	ModTime     time.Time // zero while the take is still being written
+	Size        int64
+	// ETag is a strong validator for finished recordings. It is empty while
+	// the take is still being written, since the content keeps changing.
+	ETag string
+	// Live is set when the recording is still being written. The content is
+	// a snapshot of the audio captured up to the moment it was opened.
+	Live bool
+}
+
+// OpenRecordingAudio opens the audio file of a recording. A take that is still
+// being written is returned as a consistent snapshot of what has been
+// captured so far, so it can be reviewed before it is finished.
+func (m *Manager) OpenRecordingAudio(id uint) (*RecordingAudio, error) {
+	rec, err := db.GetRecordingByID(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(rec.Filename))
+	audio := &RecordingAudio{
+		Name:        rec.Filename,
+		ContentType: audioContentTypes[ext],
+	}
+	if audio.ContentType == "" {
+		audio.ContentType = "application/octet-stream"
+	}
+	path := filepath.Join(config.AppConfig.Audio.RecordingsDir, rec.Filename)
+
+	if snap, ok, err := m.openLiveSnapshot(id, path); ok {
+		if err != nil {
+			return nil, err
+		}
+		audio.ReadSeekCloser = snap
+		audio.Size = snap.Size()
+		audio.Live = true
+		return audio, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: audio file is missing", ErrRecordingNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	audio.ReadSeekCloser = f
+	audio.Size = info.Size()
+	audio.ModTime = info.ModTime()
+	audio.ETag = fmt.Sprintf(`"%d-%x-%x"`, rec.ID, info.Size(), info.ModTime().UnixNano())
+	return audio, nil
+}
+
+// openLiveSnapshot opens a snapshot of the take with the given ID if it is the
+// one being written. It reports whether it was.
+func (m *Manager) openLiveSnapshot(id uint, path string) (liveSnapshot, bool, error) {
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
+
+	if m.recorder == nil || m.recorder.rec.ID != id {
+		return nil, false, nil
+	}
+	snap, err := m.recorder.writer.snapshot(path)
+	return snap, true, err
+}
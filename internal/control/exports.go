@@ -0,0 +1,390 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/db"
+	"nixon/internal/export"
+	"nixon/internal/slogger"
+	"nixon/internal/websocket"
+)
+
+// progressInterval limits how often the progress of a running export is
+// stored and broadcast.
+const progressInterval = 500 * time.Millisecond
+
+var (
+	// ErrExportNotFound is returned when no export job has the requested ID.
+	ErrExportNotFound = errors.New("export not found")
+	// ErrUnsupportedFormat is returned when no encoder is registered for an export format.
+	ErrUnsupportedFormat = errors.New("unsupported export format")
+	// ErrExportNotReady is returned when downloading an export that has not finished.
+	ErrExportNotReady = errors.New("export is not finished")
+)
+
+// exporter runs export jobs one at a time in the background. Jobs are
+// queued in the database, so pending work survives a restart.
+type exporter struct {
+	wake chan struct{}
+
+	mu        sync.Mutex // guards the running job; held while a job is deleted
+	current   *common.ExportJob
+	cancelJob context.CancelFunc
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func newExporter() *exporter {
+	return &exporter{wake: make(chan struct{}, 1)}
+}
+
+// notify wakes the worker to look for queued jobs.
+func (e *exporter) notify() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel stops the running job if it matches fn.
+func (e *exporter) cancel(fn func(job *common.ExportJob) bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.current != nil && fn(e.current) {
+		e.cancelJob()
+	}
+}
+
+// start makes job the running one, unless it was deleted after the worker
+// picked it from the queue.
+func (e *exporter) start(job *common.ExportJob, cancel context.CancelFunc) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := db.GetExportJob(job.ID); errors.Is(err, db.ErrNotFound) {
+		return false
+	}
+	e.current, e.cancelJob = job, cancel
+	return true
+}
+
+// StartExports registers the optional encoders and starts working through
+// queued export jobs, including any interrupted by a restart.
+func (m *Manager) StartExports() {
+	if err := export.RegisterFFmpeg(config.AppConfig.Export.FFmpegPath); err != nil {
+		slogger.Log.Info("ffmpeg not available, opus and mp3 exports disabled", "err", err)
+	}
+
+	e := m.exports
+	ctx, cancel := context.WithCancel(context.Background())
+	e.stop, e.done = cancel, make(chan struct{})
+	go func() {
+		defer close(e.done)
+		m.runExports(ctx)
+	}()
+	e.notify()
+}
+
+// StopExports stops the export worker. A job cut short is queued again and
+// restarts from the beginning the next time exports are started.
+func (m *Manager) StopExports() {
+	if m.exports.stop == nil {
+		return
+	}
+	m.exports.stop()
+	<-m.exports.done
+}
+
+// ExportFormats returns the formats recordings can be exported to.
+func (m *Manager) ExportFormats() []string {
+	return export.Formats()
+}
+
+// ExportRecording queues an export of a finished recording.
+func (m *Manager) ExportRecording(id uint, req common.ExportRequest) (*common.ExportJob, error) {
+	format := strings.ToLower(req.Format)
+	if _, ok := export.Lookup(format); !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedFormat, req.Format)
+	}
+	m.recMux.Lock()
+	active := m.recorder != nil && m.recorder.rec.ID == id
+	m.recMux.Unlock()
+	if active {
+		return nil, ErrRecordingInUse
+	}
+
+	job := &common.ExportJob{
+		RecordingID: id,
+		Format:      format,
+		Bitrate:     req.Bitrate,
+		Status:      common.JobQueued,
+	}
+	if err := db.CreateExportJob(job); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, ErrRecordingNotFound
+		}
+		return nil, err
+	}
+	websocket.Publish(websocket.TopicJobs, "job_updated", job)
+	m.exports.notify()
+	return job, nil
+}
+
+// GetExportJobs returns the export jobs of a recording, or of all recordings
+// if recordingID is 0, newest first.
+func (m *Manager) GetExportJobs(recordingID uint) ([]common.ExportJob, error) {
+	return db.GetExportJobs(recordingID)
+}
+
+// GetExportJob returns a single export job.
+func (m *Manager) GetExportJob(id uint) (*common.ExportJob, error) {
+	job, err := db.GetExportJob(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrExportNotFound
+	}
+	return job, err
+}
+
+// DeleteExportJob removes an export along with its file, cancelling it if it
+// is running. The worker can neither start the job nor store its output
+// while the row is read and deleted, so no file is left behind: a running
+// job removes its own partial output once cancelled.
+func (m *Manager) DeleteExportJob(id uint) error {
+	e := m.exports
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job, err := db.GetExportJob(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrExportNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteExportJob(id); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return ErrExportNotFound
+		}
+		return err
+	}
+	if e.current != nil && e.current.ID == id {
+		e.cancelJob()
+	}
+	removeExportFiles([]common.ExportJob{*job})
+	return nil
+}
+
+// OpenExport opens the file of a finished export for download. It is named
+// after the recording, with the extension of the export format.
+func (m *Manager) OpenExport(id uint) (*RecordingAudio, error) {
+	job, err := m.GetExportJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != common.JobDone {
+		return nil, ErrExportNotReady
+	}
+	rec, err := m.GetRecording(job.RecordingID)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(config.AppConfig.Export.Dir, job.Filename))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: exported file is missing", ErrExportNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ext := filepath.Ext(job.Filename)
+	contentType := "application/octet-stream"
+	if enc, ok := export.Lookup(job.Format); ok {
+		contentType = enc.ContentType()
+	}
+	return &RecordingAudio{
+		ReadSeekCloser: f,
+		Name:           strings.TrimSuffix(rec.Filename, filepath.Ext(rec.Filename)) + ext,
+		ContentType:    contentType,
+		ModTime:        info.ModTime(),
+		Size:           info.Size(),
+		ETag:           fmt.Sprintf(`"e%d-%x-%x"`, job.ID, info.Size(), info.ModTime().UnixNano()),
+	}, nil
+}
+
+// removeExportFiles deletes the output files of jobs.
+func removeExportFiles(jobs []common.ExportJob) {
+	for _, job := range jobs {
+		if job.Filename == "" {
+			continue
+		}
+		path := filepath.Join(config.AppConfig.Export.Dir, job.Filename)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			slogger.Log.Warn("Failed to remove exported file", "err", err, "file", job.Filename)
+		}
+	}
+}
+
+// runExports works through queued jobs until ctx is cancelled, sleeping
+// while the queue is empty.
+func (m *Manager) runExports(ctx context.Context) {
+	for {
+		job, err := db.NextExportJob()
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			slogger.Log.Error("Failed to look up queued exports", "err", err)
+		}
+		if err == nil {
+			m.runExport(ctx, job)
+			if ctx.Err() == nil {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.exports.wake:
+		}
+	}
+}
+
+// runExport encodes one job and records its outcome.
+func (m *Manager) runExport(ctx context.Context, job *common.ExportJob) {
+	e := m.exports
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if !e.start(job, cancel) {
+		return
+	}
+
+	job.Status, job.Progress, job.Error = common.JobRunning, 0, ""
+	m.saveExportJob(job)
+	slogger.Log.Info("Export started", "job", job.ID, "recording", job.RecordingID, "format", job.Format)
+
+	err := m.encodeExport(jobCtx, job)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.current, e.cancelJob = nil, nil
+	switch {
+	case ctx.Err() != nil:
+		// Shutting down: leave the job for the next start.
+		job.Status, job.Progress = common.JobQueued, 0
+		removeExportFiles([]common.ExportJob{*job})
+		job.Filename, job.FileSize = "", 0
+	case jobCtx.Err() != nil:
+		// Deleted while running, along with its recording or on its own.
+		removeExportFiles([]common.ExportJob{*job})
+		slogger.Log.Info("Export cancelled", "job", job.ID)
+		return
+	case err != nil:
+		job.Status, job.Error, job.FinishedAt = common.JobFailed, err.Error(), time.Now()
+		slogger.Log.Error("Export failed", "err", err, "job", job.ID, "recording", job.RecordingID)
+	default:
+		job.Status, job.Progress, job.FinishedAt = common.JobDone, 1, time.Now()
+		slogger.Log.Info("Export finished", "job", job.ID, "file", job.Filename, "size", job.FileSize)
+	}
+	m.saveExportJob(job)
+}
+
+// encodeExport decodes the job's recording and encodes it into the exports
+// directory, filling in the job's Filename and FileSize.
+func (m *Manager) encodeExport(ctx context.Context, job *common.ExportJob) error {
+	enc, ok := export.Lookup(job.Format)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnsupportedFormat, job.Format)
+	}
+	rec, err := db.GetRecordingByID(job.RecordingID)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrRecordingNotFound
+	}
+	if err != nil {
+		return err
+	}
+	src, info, err := openRecordingAudio(rec)
+	if err != nil {
+		return fmt.Errorf("opening recording: %w", err)
+	}
+	defer src.Close()
+
+	dir := config.AppConfig.Export.Dir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating exports directory: %w", err)
+	}
+	base := strings.TrimSuffix(rec.Filename, filepath.Ext(rec.Filename))
+	filename := fmt.Sprintf("%s_%d%s", base, job.ID, enc.Extension())
+	path := filepath.Join(dir, filename)
+	os.Remove(path) // partial output of an attempt interrupted by a crash
+
+	progress := &progressSource{
+		ctx:   ctx,
+		src:   src,
+		total: info.Frames,
+		report: func(p float64) {
+			job.Progress = p
+			m.saveExportJob(job)
+		},
+	}
+	err = enc.Encode(ctx, path, progress, export.Format{
+		SampleRate:    info.SampleRate,
+		Channels:      info.Channels,
+		BitsPerSample: info.BitsPerSample,
+		Frames:        info.Frames,
+	}, export.Options{Bitrate: job.Bitrate, Metadata: vorbisComments(rec, info.SampleRate)})
+	if err != nil {
+		return err
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	job.Filename, job.FileSize = filename, st.Size()
+	return nil
+}
+
+// saveExportJob stores a job's state and broadcasts it.
+func (m *Manager) saveExportJob(job *common.ExportJob) {
+	if err := db.SaveExportJob(job); err != nil {
+		slogger.Log.Error("Failed to save export job", "err", err, "job", job.ID)
+	}
+	websocket.Publish(websocket.TopicJobs, "job_updated", job)
+}
+
+// progressSource reports how far an encoder has read through its source and
+// stops it once ctx is cancelled.
+type progressSource struct {
+	ctx        context.Context
+	src        audioReader
+	total      int64
+	read       int64
+	lastReport time.Time
+	report     func(progress float64)
+}
+
+func (p *progressSource) ReadFrames(buf []float32) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.src.ReadFrames(buf)
+	p.read += int64(n)
+	if p.total > 0 && time.Since(p.lastReport) >= progressInterval {
+		p.lastReport = time.Now()
+		p.report(min(float64(p.read)/float64(p.total), 1))
+	}
+	return n, err
+}
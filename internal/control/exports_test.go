@@ -0,0 +1,69 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/db"
+)
+
+// exportFiles lists the files in the exports directory.
+func exportFiles(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir(config.AppConfig.Export.Dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestDeleteExportJobLeavesNoFile(t *testing.T) {
+	dir := setupRecordings(t)
+	config.AppConfig.Export.Dir = filepath.Join(dir, "exports")
+	rec := addTake(t, dir, "take.wav", 8000, 1, make([]float32, 8000))
+	m := &Manager{exports: newExporter()}
+
+	// The worker picks a queued job from the database just as it is deleted.
+	job, err := m.ExportRecording(rec.ID, common.ExportRequest{Format: "flac"})
+	if err != nil {
+		t.Fatalf("ExportRecording: %v", err)
+	}
+	picked, err := db.NextExportJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteExportJob(job.ID); err != nil {
+		t.Fatalf("DeleteExportJob: %v", err)
+	}
+	m.runExport(context.Background(), picked)
+	if files := exportFiles(t); len(files) > 0 {
+		t.Fatalf("deleted job left %q behind", files)
+	}
+	if _, err := db.GetExportJob(job.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("deleted job still stored: %v", err)
+	}
+
+	// A finished job takes its file with it.
+	if job, err = m.ExportRecording(rec.ID, common.ExportRequest{Format: "flac"}); err != nil {
+		t.Fatalf("ExportRecording: %v", err)
+	}
+	m.runExport(context.Background(), job)
+	if job.Status != common.JobDone || len(exportFiles(t)) != 1 {
+		t.Fatalf("export ended %s with files %q, want one file", job.Status, exportFiles(t))
+	}
+	if err := m.DeleteExportJob(job.ID); err != nil {
+		t.Fatalf("DeleteExportJob: %v", err)
+	}
+	if files := exportFiles(t); len(files) > 0 {
+		t.Fatalf("deleted job left %q behind", files)
+	}
+}
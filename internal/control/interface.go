@@ -14,6 +14,8 @@ type ControlHandler interface {
 	// Recording Control
 	StartRecording() error
 	StopRecording() error
+	PauseRecording() error
+	ResumeRecording() error
 
 	// Streaming Control
 	StartStream(streamType string) error
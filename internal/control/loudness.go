@@ -0,0 +1,97 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"nixon/internal/common"
+	"nixon/internal/db"
+	"nixon/internal/loudness"
+	"nixon/internal/slogger"
+	"nixon/internal/wav"
+	"nixon/internal/websocket"
+)
+
+// setLoudness copies loudness measurements into a recording.
+func setLoudness(rec *common.Recording, r loudness.Result) {
+	rec.Loudness = &r.Integrated
+	rec.LoudnessRange = &r.Range
+	rec.SamplePeak = &r.SamplePeak
+	rec.TruePeak = &r.TruePeak
+}
+
+// bextLoudness returns the measurements of rec for its bext chunk, or nil if
+// it has not been analyzed.
+func bextLoudness(rec *common.Recording) *wav.BextLoudness {
+	if rec.Loudness == nil || rec.LoudnessRange == nil || rec.TruePeak == nil {
+		return nil
+	}
+	return &wav.BextLoudness{
+		Integrated: *rec.Loudness,
+		Range:      *rec.LoudnessRange,
+		TruePeak:   *rec.TruePeak,
+	}
+}
+
+// analyzeRecordings measures the loudness of finished recordings that have
+// none, such as takes recovered after a crash or made before loudness was
+// measured, until they are all done or ctx is cancelled. The measurements
+// are embedded in each file as well as stored.
+func (m *Manager) analyzeRecordings(ctx context.Context) {
+	recordings, err := db.GetUnanalyzedRecordings()
+	if err != nil {
+		slogger.Log.Error("Failed to look up recordings to analyze", "err", err)
+		return
+	}
+	if len(recordings) > 0 {
+		slogger.Log.Info("Measuring loudness of older recordings", "count", len(recordings))
+	}
+
+	for _, rec := range recordings {
+		r, err := measureRecording(ctx, &rec)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slogger.Log.Warn("Failed to measure loudness", "err", err, "file", rec.Filename)
+			continue
+		}
+		setLoudness(&rec, r)
+		if err := db.SetLoudness(&rec); err != nil {
+			slogger.Log.Error("Failed to store loudness", "err", err, "id", rec.ID)
+			continue
+		}
+		if err := m.writeFileMetadata(&rec); err != nil {
+			slogger.Log.Warn("Failed to add loudness to recording file", "err", err, "file", rec.Filename)
+		}
+		websocket.Publish(websocket.TopicRecordings, "recording_updated", &rec)
+	}
+}
+
+// measureRecording decodes a recording and measures its loudness.
+func measureRecording(ctx context.Context, rec *common.Recording) (loudness.Result, error) {
+	src, info, err := openRecordingAudio(rec)
+	if err != nil {
+		return loudness.Result{}, fmt.Errorf("opening recording: %w", err)
+	}
+	defer src.Close()
+
+	meter := loudness.NewMeter(info.SampleRate, info.Channels)
+	buf := make([]float32, 8192*info.Channels)
+	for {
+		if err := ctx.Err(); err != nil {
+			return loudness.Result{}, err
+		}
+		n, err := src.ReadFrames(buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return loudness.Result{}, fmt.Errorf("decoding recording: %w", err)
+		}
+		meter.Write(buf[:n*info.Channels])
+	}
+	return meter.Result(), nil
+}
@@ -0,0 +1,44 @@
+package control
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nixon/internal/db"
+)
+
+func TestAnalyzeRecordingsEmbedsLoudness(t *testing.T) {
+	dir := setupRecordings(t)
+	samples := make([]float32, 2*5*48000)
+	for i := range len(samples) / 2 {
+		v := float32(0.1 * math.Sin(2*math.Pi*997*float64(i)/48000)) // -20 dBFS
+		samples[2*i], samples[2*i+1] = v, v
+	}
+	rec := addTake(t, dir, "take.wav", 48000, 2, samples)
+	path := filepath.Join(dir, rec.Filename)
+
+	m := &Manager{}
+	m.analyzeRecordings(context.Background())
+
+	stored, err := db.GetRecordingByID(rec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Loudness == nil || math.Abs(*stored.Loudness+20) > 0.1 {
+		t.Fatalf("stored loudness = %v, want -20 LUFS", stored.Loudness)
+	}
+	bext := findChunk(t, path, "bext")
+	if len(bext) < 418 {
+		t.Fatalf("bext chunk of %d bytes after analysis", len(bext))
+	}
+	if got := int16(binary.LittleEndian.Uint16(bext[412:])); got != int16(math.Round(*stored.Loudness*100)) {
+		t.Fatalf("bext loudness = %d hundredths, want %.2f LUFS as stored", got, *stored.Loudness)
+	}
+	if st, err := os.Stat(path); err != nil || stored.FileSize != st.Size() {
+		t.Fatalf("stored file size = %d, want the size of the rewritten file", stored.FileSize)
+	}
+}
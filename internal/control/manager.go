@@ -1,14 +1,32 @@
 package control
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"nixon/internal/audio"
 	"nixon/internal/common"
 	"nixon/internal/config"
+	"nixon/internal/db"
 	"nixon/internal/slogger"
 	"nixon/internal/websocket"
 	"sync"
 	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
+// captureBlock is the duration of audio read from the source per iteration.
+const captureBlock = 20 * time.Millisecond
+
+// ErrAudioNotRunning is returned when recording is requested before the
+// audio engine has been started.
+var ErrAudioNotRunning = errors.New("audio engine is not running")
+
+// ErrAudioRunning is returned by StartAudio when the engine is already running.
+var ErrAudioRunning = errors.New("audio engine is already running")
+
 var (
 	managerInstance *Manager
 	once            sync.Once
@@ -19,7 +37,25 @@ type Manager struct {
 	// pipewireManager *pipewire.Manager // We will re-integrate this later.
 
 	status    common.AudioStatus
+	history   []common.StateTransition
 	statusMux sync.RWMutex
+
+	source   audio.Source
+	recorder *recorder
+	preRoll  *ringBuffer
+	recMux   sync.Mutex
+
+	vad   *audio.VAD   // only touched by the capture goroutine
+	meter *audio.Meter // likewise
+
+	exports  *exporter
+	peaksMux sync.Mutex // serializes computing waveforms of older recordings
+	fileMux  sync.Mutex // serializes rewriting the metadata of finished files
+
+	// workers runs every goroutine the engine starts; cancel stops them all.
+	workers    *errgroup.Group
+	cancel     context.CancelFunc
+	workersMux sync.Mutex
 }
 
 // GetManager initializes and returns the singleton Manager instance.
@@ -29,6 +65,7 @@ func GetManager() (*Manager, error) {
 			status: common.AudioStatus{
 				State: common.StateStopped,
 			},
+			exports: newExporter(),
 		}
 	})
 	return managerInstance, nil
@@ -41,10 +78,12 @@ func (m *Manager) GetStatus() common.AudioStatus {
 	return m.status
 }
 
-// setStatus updates the current audio status and broadcasts it.
-func (m *Manager) setStatus(newStatus common.AudioStatus) {
+// updateStatus applies fn to the current audio status and broadcasts the result.
+// Fields fn does not touch are preserved.
+func (m *Manager) updateStatus(fn func(*common.AudioStatus)) {
 	m.statusMux.Lock()
-	m.status = newStatus
+	fn(&m.status)
+	newStatus := m.status
 	m.statusMux.Unlock()
 
 	// Broadcast the new status to all connected WebSocket clients.
@@ -55,74 +94,411 @@ func (m *Manager) setStatus(newStatus common.AudioStatus) {
 func (m *Manager) StartAudio() error {
 	slogger.Log.Info("Control Manager: Starting audio processing...")
 
-	// In a real implementation, this would start the GStreamer/Pipewire pipeline.
-	// For now, we will just simulate the state change.
+	m.workersMux.Lock()
+	defer m.workersMux.Unlock()
+	if m.cancel != nil {
+		return ErrAudioRunning
+	}
+
+	cfg := config.AppConfig.Audio
+	recoverDeletes(cfg.RecordingsDir)
+	m.recoverUnfinished(cfg.RecordingsDir)
+
+	source, err := newSource(cfg)
+	if err != nil {
+		return fmt.Errorf("creating audio source: %w", err)
+	}
+	if err := source.Open(); err != nil {
+		return fmt.Errorf("opening audio source: %w", err)
+	}
+	slogger.Log.Info("Audio source opened", "type", cfg.Source.Type, "sample_rate", source.SampleRate(), "channels", source.Channels())
+
+	vad, err := newVAD(config.AppConfig.AutoRec, source.SampleRate(), source.Channels())
+	if err != nil {
+		source.Close()
+		return fmt.Errorf("creating voice activity detector: %w", err)
+	}
+
+	preRollSecs := config.AppConfig.AutoRec.PreRollSecs
+	if preRollSecs < 0 || preRollSecs > maxPreRollSecs {
+		slogger.Log.Warn("Pre-roll out of range, clamping", "pre_roll_secs", preRollSecs, "max", maxPreRollSecs)
+		preRollSecs = min(max(preRollSecs, 0), maxPreRollSecs)
+	}
+
+	m.recMux.Lock()
+	m.source = source
+	m.vad = vad
+	m.meter = newMeter(cfg, source.SampleRate(), source.Channels())
+	m.preRoll = newRingBuffer(preRollSecs*source.SampleRate(), source.Channels())
+	m.rearmLocked()
+	m.recMux.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	workers, ctx := errgroup.WithContext(ctx)
+	workers.Go(func() error {
+		defer cancel() // the engine stops as a whole once capture ends
+		return m.captureLoop(ctx, source)
+	})
+	workers.Go(func() error {
+		m.analyzeRecordings(ctx)
+		return nil
+	})
+	m.workers, m.cancel = workers, cancel
+	go m.watchWorkers(workers)
 
-	go m.simulateVAD()
+	if m.GetStatus().AudioError != "" {
+		m.updateStatus(func(s *common.AudioStatus) { s.AudioError = "" })
+	}
 	return nil
 }
 
-// StopAudio stops the main audio processing loop.
-func (m *Manager) StopAudio() error {
-	slogger.Log.Info("Control Manager: Stopping audio processing.")
-	// TODO: Add logic to stop the simulateVAD goroutine.
-	return nil
+// watchWorkers waits for the workers of one run of the engine to exit. When
+// they stop on their own rather than through StopAudio, because the source
+// ended or failed, it marks the engine stopped so StartAudio can run it again
+// and reports any failure in the status.
+func (m *Manager) watchWorkers(workers *errgroup.Group) {
+	err := workers.Wait()
+
+	m.workersMux.Lock()
+	defer m.workersMux.Unlock()
+	if m.workers != workers {
+		return // StopAudio already cleared the run and returns err itself
+	}
+	m.workers, m.cancel = nil, nil
+	slogger.Log.Warn("Audio engine stopped on its own", "err", err)
+	if err != nil {
+		m.updateStatus(func(s *common.AudioStatus) { s.AudioError = err.Error() })
+	}
 }
 
-// simulateVAD is a placeholder for the real VAD logic from GStreamer.
-// It will periodically toggle the recording state to test the UI.
-func (m *Manager) simulateVAD() {
-	slogger.Log.Info("Starting VAD simulation...")
-	isRecording := false
-	ticker := time.NewTicker(15 * time.Second) // Toggle every 15 seconds
-	defer ticker.Stop()
-
-	for range ticker.C {
-		if isRecording {
-			slogger.Log.Debug("VAD SIM: Silence detected, stopping recording.")
-			m.StopRecording()
-			isRecording = false
-		} else {
-			slogger.Log.Debug("VAD SIM: Voice detected, starting recording.")
-			m.StartRecording()
-			isRecording = true
+// captureLoop pulls blocks of frames from the source and hands them to the
+// active recorder, if any, and to the activity detector, until ctx is
+// cancelled or the source fails. On the way out it finalizes any recording
+// in progress so no take is left truncated.
+func (m *Manager) captureLoop(ctx context.Context, source audio.Source) error {
+	defer m.detachSource()
+
+	frames := source.SampleRate() * int(captureBlock) / int(time.Second)
+	buf := make([]float32, frames*source.Channels())
+
+	for {
+		select {
+		case <-ctx.Done():
+			slogger.Log.Info("Audio capture cancelled")
+			return nil
+		default:
+		}
+
+		n, err := source.ReadFrames(buf)
+		if errors.Is(err, io.EOF) {
+			slogger.Log.Info("Audio source ended, stopping capture")
+			return nil
 		}
+		if err != nil {
+			slogger.Log.Error("Audio source read failed, stopping capture", "err", err)
+			return fmt.Errorf("reading audio source: %w", err)
+		}
+		block := buf[:n*source.Channels()]
+		m.process(block)
+		m.detectActivity(block)
+		m.updateMeter(block)
+	}
+}
+
+// detachSource finalizes any active recording and closes the source after
+// capture has ended.
+func (m *Manager) detachSource() {
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
+
+	if m.recorder != nil {
+		m.finishRecordingLocked()
+	}
+	if err := m.source.Close(); err != nil {
+		slogger.Log.Warn("Failed to close audio source", "err", err)
+	}
+	m.source = nil
+	m.statusMux.Lock()
+	m.status.MasterPeak = 0
+	m.statusMux.Unlock()
+	if m.GetStatus().State == common.StateArmed {
+		m.transition(eventDisarm, nil)
+	}
+}
+
+// rearmLocked arms the recorder for the activity detector when auto-record is
+// enabled and audio is running. recMux must be held.
+func (m *Manager) rearmLocked() {
+	if !config.AppConfig.AutoRec.Enabled || m.source == nil {
+		return
+	}
+	if err := m.transition(eventArm, nil); err != nil {
+		slogger.Log.Warn("Could not arm auto-record", "err", err)
 	}
 }
 
+// process writes a block of captured audio to the active recording, ending
+// the take once a pending post-roll is done. Without one the block goes to
+// the pre-roll buffer instead, which is empty while a take is recorded, so
+// a take's pre-roll never repeats audio already in the previous take.
+func (m *Manager) process(samples []float32) {
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
+
+	if m.recorder == nil {
+		m.preRoll.write(samples)
+		return
+	}
+	if m.recorder.paused() {
+		return
+	}
+	if err := m.writeTakeLocked(samples); err != nil {
+		slogger.Log.Error("Failed to write audio, stopping recording", "err", err)
+		if m.recorder != nil {
+			m.finishRecordingLocked()
+		}
+		return
+	}
+	if m.recorder.postRollDone() {
+		slogger.Log.Info("Auto-record: post-roll complete, stopping recording", "file", m.recorder.rec.Filename)
+		m.finishRecordingLocked()
+	}
+}
+
+// writeTakeLocked writes samples to the active take, rolling over to a new
+// part whenever the take reaches its maximum length so no sample is lost
+// between parts. recMux must be held.
+func (m *Manager) writeTakeLocked(samples []float32) error {
+	channels := m.recorder.channels
+	for len(samples) > 0 {
+		room := m.recorder.maxFrames - m.recorder.writer.Frames()
+		if room <= 0 {
+			if err := m.rolloverLocked(); err != nil {
+				return err
+			}
+			continue
+		}
+		n := int(min(int64(len(samples)/channels), room)) * channels
+		if err := m.recorder.write(samples[:n]); err != nil {
+			return err
+		}
+		samples = samples[n:]
+	}
+	return nil
+}
+
+// rolloverLocked finalizes the active take and continues the session in a
+// new part. recMux must be held.
+func (m *Manager) rolloverLocked() error {
+	prev := m.recorder.rec
+	next, err := m.recorder.next(config.AppConfig.Audio.RecordingsDir)
+	if err != nil {
+		// The previous part is already finalized; settle the state machine.
+		m.recorder = nil
+		m.transition(eventStop, nil)
+		m.transition(eventFinalized, func(s *common.AudioStatus) {
+			s.CurrentRecFile = ""
+		})
+		m.rearmLocked()
+		return fmt.Errorf("starting next recording part: %w", err)
+	}
+	m.recorder = next
+	slogger.Log.Info("Recording reached maximum length, continuing in new part",
+		"previous", prev.Filename, "file", next.rec.Filename, "session_id", next.rec.SessionID, "part", next.rec.Part)
+
+	m.updateStatus(func(s *common.AudioStatus) {
+		s.CurrentRecFile = next.rec.Filename
+	})
+	return nil
+}
+
+// StopAudio stops every audio worker and waits for them to exit. Any take in
+// progress is finalized before it returns. Calling it while the engine is
+// not running is a no-op.
+func (m *Manager) StopAudio() error {
+	slogger.Log.Info("Control Manager: Stopping audio processing.")
+
+	m.workersMux.Lock()
+	defer m.workersMux.Unlock()
+	if m.cancel == nil {
+		return nil
+	}
+
+	m.cancel()
+	err := m.workers.Wait()
+	m.workers, m.cancel = nil, nil
+
+	slogger.Log.Info("Control Manager: Audio processing stopped.")
+	return err
+}
+
 // StartRecording starts a new recording.
 func (m *Manager) StartRecording() error {
 	slogger.Log.Info("Control Manager: Starting recording...")
+	return m.startRecording(false)
+}
+
+// startRecording starts a new take. auto marks takes triggered by the
+// activity detector, which it is then allowed to stop again; those takes
+// also begin with the contents of the pre-roll buffer.
+func (m *Manager) startRecording(auto bool) error {
 	cfg := config.AppConfig
 
-	// Here, you would interact with the database to create a new recording entry.
-	// For now, we just update the state.
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
 
-	m.setStatus(common.AudioStatus{
-		State:     common.StateRecording,
-		IsAutoRec: cfg.AutoRec.Enabled, // Reflect config
+	if m.source == nil {
+		return ErrAudioNotRunning
+	}
+	if err := m.checkTransition(eventStart); err != nil {
+		return err
+	}
+
+	sampleRate, channels := m.source.SampleRate(), m.source.Channels()
+	start := time.Now()
+	var preRoll time.Duration
+	if auto {
+		preRoll = time.Duration(m.preRoll.len()/channels) * time.Second / time.Duration(sampleRate)
+		start = start.Add(-preRoll)
+	}
+
+	rec, err := newRecorder(cfg.Audio.RecordingsDir, &common.Recording{StartTime: start, PreRoll: preRoll}, sampleRate, channels)
+	if err != nil {
+		return err
+	}
+	rec.auto = auto
+	m.recorder = rec
+	if preRoll > 0 {
+		if err := m.preRoll.drain(m.writeTakeLocked); err != nil {
+			slogger.Log.Error("Failed to write pre-roll", "err", err, "file", rec.rec.Filename)
+		}
+	} else {
+		m.preRoll.reset() // audio from before a manual take must not reach a later pre-roll
+	}
+	slogger.Log.Info("Recording started", "id", rec.rec.ID, "file", rec.rec.Filename, "auto", auto, "pre_roll", preRoll)
+
+	return m.transition(eventStart, func(s *common.AudioStatus) {
+		s.CurrentRecFile = rec.rec.Filename
+		s.IsAutoRec = cfg.AutoRec.Enabled // Reflect config
 	})
-	return nil
 }
 
 // StopRecording stops the current recording.
 func (m *Manager) StopRecording() error {
 	slogger.Log.Info("Control Manager: Stopping recording...")
-	m.setStatus(common.AudioStatus{
-		State: common.StateStopped,
-	})
+
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
+
+	return m.finishRecordingLocked()
+}
+
+// PauseRecording stops writing audio to the current take without closing it.
+// A take the activity detector started is from then on left to the user to
+// stop.
+func (m *Manager) PauseRecording() error {
+	slogger.Log.Info("Control Manager: Pausing recording...")
+
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
+
+	if err := m.transition(eventPause, nil); err != nil {
+		return err
+	}
+	m.recorder.pause()
+	return nil
+}
+
+// ResumeRecording continues a paused take. The file is stitched together
+// without the paused audio, and a marker is stored at each resume point so
+// the gap can be found in a DAW once it is embedded.
+func (m *Manager) ResumeRecording() error {
+	slogger.Log.Info("Control Manager: Resuming recording...")
+
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
+
+	if err := m.transition(eventResume, nil); err != nil {
+		return err
+	}
+	gap := m.recorder.resume()
+	slogger.Log.Info("Recording resumed", "file", m.recorder.rec.Filename, "paused_for", gap)
+
+	marker := common.Marker{
+		RecordingID: m.recorder.rec.ID,
+		Offset:      m.recorder.duration(),
+		Label:       fmt.Sprintf("Resumed after %s pause", gap.Round(100*time.Millisecond)),
+	}
+	if err := db.CreateMarker(&marker); err != nil {
+		slogger.Log.Error("Failed to store resume marker", "err", err, "file", m.recorder.rec.Filename)
+		return nil
+	}
+	websocket.Publish(websocket.TopicRecordings, "marker_added", marker)
 	return nil
 }
 
+// finishRecordingLocked finalizes the active take, passing through the
+// finalizing state. recMux must be held.
+func (m *Manager) finishRecordingLocked() error {
+	if err := m.transition(eventStop, nil); err != nil {
+		return err
+	}
+
+	rec, err := m.recorder.finish()
+	m.recorder = nil
+	if err != nil {
+		slogger.Log.Error("Failed to finalize recording", "err", err, "file", rec.Filename)
+	} else {
+		slogger.Log.Info("Recording finished", "id", rec.ID, "file", rec.Filename, "duration", rec.Duration, "size", rec.FileSize)
+	}
+
+	m.transition(eventFinalized, func(s *common.AudioStatus) {
+		s.CurrentRecFile = ""
+	})
+	m.rearmLocked()
+	return err
+}
+
 // --- Placeholder methods to satisfy the interface ---
 
 func (m *Manager) StartStream(streamType string) error {
 	slogger.Log.Info("Starting stream", "stream_type", streamType)
-	return nil
+	return m.setStreamActive(streamType, true)
 }
 
 func (m *Manager) StopStream(streamType string) error {
 	slogger.Log.Info("Stopping stream", "stream_type", streamType)
+	return m.setStreamActive(streamType, false)
+}
+
+// setStreamActive flips a stream on or off in ActiveStreams. Streams run
+// alongside the recorder, so this never touches the recorder state.
+func (m *Manager) setStreamActive(streamType string, active bool) error {
+	m.statusMux.Lock()
+	if m.status.ActiveStreams[streamType] == active {
+		from, ev := common.StateStopped, "stop "+streamType+" stream"
+		if active {
+			from, ev = common.StateStreaming, "start "+streamType+" stream"
+		}
+		m.statusMux.Unlock()
+		return &TransitionError{Event: ev, From: from}
+	}
+
+	// Copy rather than mutate, since earlier snapshots share the old map.
+	streams := make(map[string]bool, len(m.status.ActiveStreams)+1)
+	for k, v := range m.status.ActiveStreams {
+		streams[k] = v
+	}
+	if active {
+		streams[streamType] = true
+	} else {
+		delete(streams, streamType)
+	}
+	m.status.ActiveStreams = streams
+	newStatus := m.status
+	m.statusMux.Unlock()
+
+	websocket.BroadcastStatus(newStatus)
 	return nil
 }
 
@@ -132,14 +508,3 @@ func (m *Manager) GetAudioDevices() ([]common.AudioDevice, error) {
 		{DeviceName: "default", Description: "Default System Device"},
 	}, nil
 }
-func (m *Manager) GetRecordings() ([]common.Recording, error) {
-	slogger.Log.Debug("Manager: GetRecordings called (placeholder)")
-	// In a real implementation, this would query the database.
-	return []common.Recording{}, nil
-}
-
-func (m *Manager) DeleteRecording(id uint) error {
-	slogger.Log.Debug("Manager: DeleteRecording called (placeholder)", "id", id)
-	// In a real implementation, this would delete from the database.
-	return nil
-}
@@ -0,0 +1,250 @@
+package control
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/db"
+	"nixon/internal/wav"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// waitStopped waits for the engine of m to stop on its own.
+func waitStopped(t *testing.T, m *Manager) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.workersMux.Lock()
+		stopped := m.cancel == nil
+		m.workersMux.Unlock()
+		if stopped {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("audio engine still running after its source ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStartAudioAfterSourceEnds(t *testing.T) {
+	dir := setupRecordings(t)
+	source := filepath.Join(dir, "source.wav")
+	w, err := wav.Create(source, 8000, 1, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFrames(make([]float32, 800)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	config.AppConfig.Audio = config.AudioSettings{
+		RecordingsDir: dir,
+		Source:        config.SourceSettings{Type: "file", File: source},
+	}
+
+	m := &Manager{status: common.AudioStatus{State: common.StateStopped}}
+	if err := m.StartAudio(); err != nil {
+		t.Fatalf("StartAudio: %v", err)
+	}
+	waitStopped(t, m)
+	if s := m.GetStatus(); s.AudioError != "" {
+		t.Fatalf("status reports %q after the source ended cleanly", s.AudioError)
+	}
+
+	if err := m.StartAudio(); err != nil {
+		t.Fatalf("StartAudio after the source ended: %v", err)
+	}
+	if err := m.StopAudio(); err != nil {
+		t.Fatalf("StopAudio: %v", err)
+	}
+}
+
+func TestWatchWorkersReportsFailure(t *testing.T) {
+	m := &Manager{status: common.AudioStatus{State: common.StateStopped}}
+	var workers errgroup.Group
+	release := make(chan struct{})
+	workers.Go(func() error {
+		<-release
+		return errors.New("reading audio source: device unplugged")
+	})
+	m.workers, m.cancel = &workers, func() {}
+	done := make(chan struct{})
+	go func() {
+		m.watchWorkers(&workers)
+		close(done)
+	}()
+	close(release)
+	<-done
+
+	if m.workers != nil || m.cancel != nil {
+		t.Fatal("worker state not cleared after the workers failed")
+	}
+	if s := m.GetStatus(); s.AudioError != "reading audio source: device unplugged" {
+		t.Fatalf("AudioError = %q, want the read failure", s.AudioError)
+	}
+}
+
+func TestPauseHandsAutoTakeToUser(t *testing.T) {
+	setupRecordings(t)
+	config.AppConfig.AutoRec = config.AutoRecord{Enabled: true, PostRollSecs: 1}
+	m := idleManager()
+	if err := m.startRecording(true); err != nil {
+		t.Fatalf("starting auto take: %v", err)
+	}
+	block := make([]float32, 160) // 20 ms
+	for range 10 {
+		m.process(block)
+	}
+
+	// Silence schedules the post-roll, then the user pauses before it ends.
+	m.stopAutoRecording()
+	if err := m.PauseRecording(); err != nil {
+		t.Fatalf("PauseRecording: %v", err)
+	}
+	m.stopAutoRecording() // the detector sees more silence while paused
+	for range 100 {
+		m.process(block)
+	}
+	if s := m.GetStatus(); s.State != common.StatePaused || m.recorder == nil {
+		t.Fatalf("state while paused = %s, want the take still paused", s.State)
+	}
+
+	if err := m.ResumeRecording(); err != nil {
+		t.Fatalf("ResumeRecording: %v", err)
+	}
+	for range 100 { // twice the post-roll
+		m.process(block)
+	}
+	m.stopAutoRecording()
+	if s := m.GetStatus(); s.State != common.StateRecording || m.recorder == nil {
+		t.Fatalf("state after resuming = %s, want the take to run until stopped", s.State)
+	}
+
+	rec := m.recorder.rec
+	if err := m.StopRecording(); err != nil {
+		t.Fatalf("StopRecording: %v", err)
+	}
+	if rec.PostRoll != 0 || rec.Duration != 2200*time.Millisecond {
+		t.Fatalf("take of %v with %v post-roll, want 2.2s without one", rec.Duration, rec.PostRoll)
+	}
+}
+
+func TestRolloverKeepsEverySample(t *testing.T) {
+	dir := setupRecordings(t)
+	config.AppConfig.AutoRec = config.AutoRecord{Enabled: true, PostRollSecs: 10, MaxRecordMins: 1}
+	m := idleManager()
+	if err := m.startRecording(true); err != nil {
+		t.Fatalf("starting auto take: %v", err)
+	}
+	first := m.recorder.rec
+
+	// A ramp 7 steps of 16 bits apart per frame, so a sample dropped or
+	// repeated at a part boundary shows up. Blocks straddle the boundaries.
+	const sampleRate, partFrames = 8000, 60 * 8000
+	value := func(i int) float32 { return float32(i*7%60000-30000) / 32768 }
+	block := make([]float32, 333)
+	frames := 0
+	for m.recorder != nil {
+		if frames > 3*partFrames {
+			t.Fatal("take did not end after its post-roll")
+		}
+		for i := range block {
+			block[i] = value(frames + i)
+		}
+		m.process(block)
+		if frames += len(block); frames >= 115*sampleRate && m.recorder != nil && m.recorder.stopAt == 0 && m.recorder.rec.Part == 2 {
+			m.stopAutoRecording() // 10 s of post-roll from 55 s into the second part
+		}
+	}
+
+	parts, _, err := db.QueryRecordings(common.RecordingQuery{Session: first.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("take split into %d parts, want 3", len(parts))
+	}
+	var got []float32
+	for i, rec := range parts {
+		if rec.Part != i+1 || (i == 0 && rec.SessionID != 0) || (i > 0 && rec.SessionID != first.ID) {
+			t.Fatalf("part %d has Part %d and SessionID %d, want %d of session %d", i+1, rec.Part, rec.SessionID, i+1, first.ID)
+		}
+		r, err := wav.Open(filepath.Join(dir, rec.Filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := r.Info()
+		if want := int64(partFrames); i < 2 && info.Frames != want {
+			t.Fatalf("part %d holds %d frames, want %d", i+1, info.Frames, want)
+		}
+		buf := make([]float32, info.Frames)
+		n, err := r.ReadFrames(buf)
+		r.Close()
+		if err != nil || int64(n) != info.Frames {
+			t.Fatalf("reading part %d: %d frames, %v", i+1, n, err)
+		}
+		got = append(got, buf...)
+	}
+
+	// The last part is the rest of the post-roll, up to one block over.
+	if last := len(got) - 2*partFrames; last < 5*sampleRate || last > 5*sampleRate+len(block) {
+		t.Fatalf("last part holds %d frames, want the remaining 5 s of post-roll", last)
+	}
+	for i, v := range got {
+		if d := v - value(i); d > 1.5/32768 || d < -1.5/32768 {
+			t.Fatalf("frame %d of the session = %v, want %v", i, v, value(i))
+		}
+	}
+}
+
+// metadataSpy keeps a copy of the recording its take's metadata is built from.
+type metadataSpy struct {
+	takeWriter
+	rec *common.Recording
+}
+
+func (s *metadataSpy) setMetadata(rec *common.Recording) {
+	copied := *rec
+	s.rec = &copied
+	s.takeWriter.setMetadata(rec)
+}
+
+func TestFinishEmbedsFinalLength(t *testing.T) {
+	setupRecordings(t)
+	config.AppConfig.AutoRec = config.AutoRecord{Enabled: true, PostRollSecs: 1}
+	m := idleManager()
+	if err := m.startRecording(true); err != nil {
+		t.Fatalf("starting auto take: %v", err)
+	}
+	spy := &metadataSpy{takeWriter: m.recorder.writer}
+	m.recorder.writer = spy
+	rec := m.recorder.rec
+
+	block := make([]float32, 160) // 20 ms
+	for range 25 {
+		m.process(block)
+	}
+	m.stopAutoRecording()
+	for i := 0; m.recorder != nil; i++ {
+		if i > 100 {
+			t.Fatal("take did not end after its post-roll")
+		}
+		m.process(block)
+	}
+
+	if spy.rec == nil {
+		t.Fatal("no metadata set on the take")
+	}
+	if spy.rec.Duration != 1500*time.Millisecond || spy.rec.PostRoll != time.Second || !spy.rec.EndTime.Equal(rec.EndTime) || rec.EndTime.IsZero() {
+		t.Fatalf("metadata built from a take of %v with %v post-roll ending %v, want 1.5s with 1s ending %v",
+			spy.rec.Duration, spy.rec.PostRoll, spy.rec.EndTime, rec.EndTime)
+	}
+}
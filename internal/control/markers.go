@@ -0,0 +1,216 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/db"
+	"nixon/internal/slogger"
+	"nixon/internal/wav"
+	"nixon/internal/websocket"
+)
+
+var (
+	// ErrMarkerNotFound is returned when a recording has no marker with the requested ID.
+	ErrMarkerNotFound = errors.New("marker not found")
+	// ErrInvalidMarker is returned when a marker lies outside its recording
+	// or its region ends before it starts.
+	ErrInvalidMarker = errors.New("invalid marker")
+)
+
+// GetMarkers returns the markers of a recording in file order.
+func (m *Manager) GetMarkers(recordingID uint) ([]common.Marker, error) {
+	if _, err := m.GetRecording(recordingID); err != nil {
+		return nil, err
+	}
+	return db.GetMarkers(recordingID)
+}
+
+// AddMarker stores a new marker on a recording.
+func (m *Manager) AddMarker(marker common.Marker) (*common.Marker, error) {
+	marker.ID = 0
+	if err := m.checkMarker(&marker); err != nil {
+		return nil, err
+	}
+	if err := db.CreateMarker(&marker); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, ErrRecordingNotFound
+		}
+		return nil, err
+	}
+	websocket.Publish(websocket.TopicRecordings, "marker_added", marker)
+	m.markersChanged(marker.RecordingID)
+	return &marker, nil
+}
+
+// UpdateMarker applies a partial update to a marker.
+func (m *Manager) UpdateMarker(recordingID, id uint, update common.MarkerUpdate) (*common.Marker, error) {
+	marker, err := db.GetMarker(recordingID, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrMarkerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Offset != nil {
+		marker.Offset = *update.Offset
+	}
+	if update.End != nil {
+		marker.End = *update.End
+	}
+	if update.Label != nil {
+		marker.Label = *update.Label
+	}
+	if update.Color != nil {
+		marker.Color = *update.Color
+	}
+	if err := m.checkMarker(marker); err != nil {
+		return nil, err
+	}
+	if err := db.SaveMarker(marker); err != nil {
+		return nil, err
+	}
+	websocket.Publish(websocket.TopicRecordings, "marker_updated", marker)
+	m.markersChanged(marker.RecordingID)
+	return marker, nil
+}
+
+// DeleteMarker removes a marker from a recording.
+func (m *Manager) DeleteMarker(recordingID, id uint) error {
+	err := db.DeleteMarker(recordingID, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrMarkerNotFound
+	}
+	if err == nil {
+		websocket.Publish(websocket.TopicRecordings, "marker_deleted", common.Marker{ID: id, RecordingID: recordingID})
+		m.markersChanged(recordingID)
+	}
+	return err
+}
+
+// DropMarker marks the current position of the take being recorded.
+func (m *Manager) DropMarker(label, color string) (*common.Marker, error) {
+	// The marker is stored with recMux held so the take cannot be finalized,
+	// and miss it when embedding, in between.
+	m.recMux.Lock()
+	if m.recorder == nil {
+		m.recMux.Unlock()
+		return nil, &TransitionError{Event: "drop marker", From: m.GetStatus().State}
+	}
+	marker := common.Marker{
+		RecordingID: m.recorder.rec.ID,
+		Offset:      m.recorder.duration(),
+		Label:       label,
+		Color:       color,
+	}
+	if marker.Label == "" {
+		marker.Label = formatOffset(marker.Offset)
+	}
+	err := db.CreateMarker(&marker)
+	m.recMux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	slogger.Log.Info("Marker dropped", "recording_id", marker.RecordingID, "offset", marker.Offset, "label", marker.Label)
+	websocket.Publish(websocket.TopicRecordings, "marker_added", marker)
+	return &marker, nil
+}
+
+// checkMarker verifies that a region ends after it starts and, for a
+// finished recording, that the marker lies within it.
+func (m *Manager) checkMarker(marker *common.Marker) error {
+	if marker.End != 0 && marker.End <= marker.Offset {
+		return fmt.Errorf("%w: region ends at %s, before its start at %s", ErrInvalidMarker, marker.End, marker.Offset)
+	}
+
+	m.recMux.Lock()
+	live := m.recorder != nil && m.recorder.rec.ID == marker.RecordingID
+	m.recMux.Unlock()
+	if live {
+		return nil
+	}
+
+	rec, err := m.GetRecording(marker.RecordingID)
+	if err != nil {
+		return err
+	}
+	if marker.Offset > rec.Duration || marker.End > rec.Duration {
+		return fmt.Errorf("%w: beyond the end of the %s recording", ErrInvalidMarker, rec.Duration)
+	}
+	return nil
+}
+
+// embedMarkers adds the stored markers of the take to its file as cue points
+// and regions.
+func (r *recorder) embedMarkers() {
+	markers, err := db.GetMarkers(r.rec.ID)
+	if err != nil {
+		slogger.Log.Error("Failed to load markers for embedding", "err", err, "id", r.rec.ID)
+		return
+	}
+
+	for _, c := range markerCues(markers, r.sampleRate, r.writer.Frames()) {
+		if c.Length == 0 {
+			r.writer.AddCue(c.Frame, c.Label)
+		} else {
+			r.writer.AddRegion(c.Frame, c.Length, c.Label)
+		}
+	}
+}
+
+// markerCues converts markers to cue points and regions of a file of the
+// given length, dropping any that lie beyond its audio.
+func markerCues(markers []common.Marker, sampleRate int, frames int64) []wav.Cue {
+	frameAt := func(d time.Duration) int64 {
+		return int64(d.Seconds() * float64(sampleRate))
+	}
+
+	var cues []wav.Cue
+	for _, mk := range markers {
+		start := frameAt(mk.Offset)
+		if start > frames {
+			continue
+		}
+		c := wav.Cue{Frame: start, Label: mk.Label}
+		if mk.End != 0 {
+			c.Length = min(frameAt(mk.End), frames) - start
+		}
+		cues = append(cues, c)
+	}
+	return cues
+}
+
+// markersChanged embeds the edited markers of a finished recording in its
+// file. Those of the take being written are embedded when it is finalized,
+// and FLAC files carry none.
+func (m *Manager) markersChanged(recordingID uint) {
+	m.recMux.Lock()
+	live := m.recorder != nil && m.recorder.rec.ID == recordingID
+	m.recMux.Unlock()
+	if live {
+		return
+	}
+
+	rec, err := db.GetRecordingByID(recordingID)
+	if err != nil {
+		slogger.Log.Warn("Failed to load recording to embed its markers", "err", err, "id", recordingID)
+		return
+	}
+	if rec.Format == common.FormatFLAC {
+		return
+	}
+	m.metadataChanged(rec)
+}
+
+// formatOffset renders an offset as m:ss, or h:mm:ss for long takes.
+func formatOffset(d time.Duration) string {
+	s := int(d.Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
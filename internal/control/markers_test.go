@@ -0,0 +1,180 @@
+package control
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/slogger"
+	"nixon/internal/wav"
+)
+
+// readCues decodes the cue points of the WAV file at path, with the labels
+// and region lengths of its LIST/adtl chunk.
+func readCues(t *testing.T, path string) []wav.Cue {
+	t.Helper()
+	le := binary.LittleEndian
+	cue, list := findChunk(t, path, "cue "), findChunk(t, path, "LIST")
+	if cue == nil && list == nil {
+		return nil
+	}
+	if len(cue) < 4 || len(cue) != 4+24*int(le.Uint32(cue)) {
+		t.Fatalf("cue chunk of %d bytes", len(cue))
+	}
+	if len(list) < 4 || string(list[:4]) != "adtl" {
+		t.Fatalf("LIST chunk %q is not adtl", list)
+	}
+
+	cues := make([]wav.Cue, le.Uint32(cue))
+	index := map[uint32]int{}
+	for i := range cues {
+		p := cue[4+24*i:]
+		if string(p[8:12]) != "data" || le.Uint32(p[4:8]) != le.Uint32(p[20:24]) {
+			t.Fatalf("cue point %d = %x", i, p[:24])
+		}
+		index[le.Uint32(p[0:4])] = i
+		cues[i].Frame = int64(le.Uint32(p[20:24]))
+	}
+	for offset := 4; offset+8 <= len(list); {
+		id, n := string(list[offset:offset+4]), int(le.Uint32(list[offset+4:]))
+		body := list[offset+8 : offset+8+n]
+		i, ok := index[le.Uint32(body)]
+		if !ok {
+			t.Fatalf("%s for unknown cue point %d", id, le.Uint32(body))
+		}
+		switch id {
+		case "labl":
+			cues[i].Label = strings.TrimRight(string(body[4:]), "\x00")
+		case "ltxt":
+			if string(body[8:12]) != "rgn " {
+				t.Fatalf("ltxt of purpose %q", body[8:12])
+			}
+			cues[i].Length = int64(le.Uint32(body[4:8]))
+		}
+		offset += 8 + n + n%2
+	}
+	return cues
+}
+
+func TestMarkersEmbeddedInFinishedTake(t *testing.T) {
+	dir := setupRecordings(t)
+	rec := addTake(t, dir, "take.wav", 8000, 1, make([]float32, 2*8000))
+	path := filepath.Join(dir, rec.Filename)
+	m := &Manager{}
+
+	verse, err := m.AddMarker(common.Marker{RecordingID: rec.ID, Offset: 500 * time.Millisecond, Label: "verse"})
+	if err != nil {
+		t.Fatalf("AddMarker: %v", err)
+	}
+	solo, err := m.AddMarker(common.Marker{RecordingID: rec.ID, Offset: time.Second, End: 1500 * time.Millisecond, Label: "solo"})
+	if err != nil {
+		t.Fatalf("AddMarker: %v", err)
+	}
+	want := []wav.Cue{{Frame: 4000, Label: "verse"}, {Frame: 8000, Length: 4000, Label: "solo"}}
+	if cues := readCues(t, path); !slices.Equal(cues, want) {
+		t.Fatalf("embedded cues = %+v, want %+v", cues, want)
+	}
+
+	for _, mk := range []*common.Marker{verse, solo} {
+		if err := m.DeleteMarker(rec.ID, mk.ID); err != nil {
+			t.Fatalf("DeleteMarker: %v", err)
+		}
+	}
+	if cues := readCues(t, path); cues != nil {
+		t.Fatalf("cues left after deleting every marker: %+v", cues)
+	}
+}
+
+// recordMarkedTake records 1.5 s of silence at 8 kHz, with a point marker
+// dropped 1 s in and two regions, the second running past the end of the
+// take. It returns the take and the writer it was recorded with.
+func recordMarkedTake(t *testing.T) (*common.Recording, takeWriter) {
+	t.Helper()
+	m := idleManager()
+	if err := m.startRecording(false); err != nil {
+		t.Fatalf("starting take: %v", err)
+	}
+	rec, writer := m.recorder.rec, m.recorder.writer
+	block := make([]float32, 160) // 20 ms
+	for range 50 {
+		m.process(block)
+	}
+	if _, err := m.DropMarker("intro", ""); err != nil {
+		t.Fatalf("DropMarker: %v", err)
+	}
+	for _, mk := range []common.Marker{
+		{RecordingID: rec.ID, Offset: 200 * time.Millisecond, End: 600 * time.Millisecond, Label: "chorus"},
+		{RecordingID: rec.ID, Offset: 800 * time.Millisecond, End: 5 * time.Second, Label: "outro"},
+	} {
+		if _, err := m.AddMarker(mk); err != nil {
+			t.Fatalf("AddMarker: %v", err)
+		}
+	}
+	for range 25 {
+		m.process(block)
+	}
+	if err := m.StopRecording(); err != nil {
+		t.Fatalf("StopRecording: %v", err)
+	}
+	return rec, writer
+}
+
+func TestMarkersEmbeddedWhenTakeIsFinalized(t *testing.T) {
+	dir := setupRecordings(t)
+	rec, _ := recordMarkedTake(t)
+
+	want := []wav.Cue{
+		{Frame: 1600, Length: 3200, Label: "chorus"},
+		{Frame: 6400, Length: 5600, Label: "outro"}, // cut at the end of the take
+		{Frame: 8000, Label: "intro"},
+	}
+	if cues := readCues(t, filepath.Join(dir, rec.Filename)); !slices.Equal(cues, want) {
+		t.Fatalf("embedded cues = %+v, want %+v", cues, want)
+	}
+}
+
+func TestFLACTakeKeepsMarkersInDatabase(t *testing.T) {
+	dir := setupRecordings(t)
+	config.AppConfig.Audio.Format = common.FormatFLAC
+	var logs bytes.Buffer
+	saved := slogger.Log
+	slogger.Log = slog.New(slog.NewTextHandler(&logs, nil))
+	t.Cleanup(func() { slogger.Log = saved })
+
+	rec, writer := recordMarkedTake(t)
+	if n := writer.(*flacTake).markers; n != 3 {
+		t.Fatalf("FLAC take skipped %d markers, want 3", n)
+	}
+	if !strings.Contains(logs.String(), "FLAC takes carry no embedded markers") {
+		t.Fatalf("skipped markers not logged:\n%s", logs.String())
+	}
+	markers, err := (&Manager{}).GetMarkers(rec.ID)
+	if err != nil || len(markers) != 3 {
+		t.Fatalf("stored markers = %+v (%v), want 3", markers, err)
+	}
+
+	// Editing a marker leaves the file alone.
+	path := filepath.Join(dir, rec.Filename)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before[:4]) != "fLaC" {
+		t.Fatalf("take starts with %q, want a FLAC file", before[:4])
+	}
+	label := "bridge"
+	if _, err := (&Manager{}).UpdateMarker(rec.ID, markers[0].ID, common.MarkerUpdate{Label: &label}); err != nil {
+		t.Fatalf("UpdateMarker: %v", err)
+	}
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(after, before) {
+		t.Fatalf("FLAC file changed by a marker edit (%v)", err)
+	}
+}
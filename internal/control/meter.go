@@ -0,0 +1,54 @@
+package control
+
+import (
+	"time"
+
+	"nixon/internal/audio"
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/slogger"
+	"nixon/internal/websocket"
+)
+
+const (
+	defaultMeterRate = 25
+	maxMeterRate     = 60
+)
+
+// newMeter creates the live level meter from the audio settings.
+func newMeter(cfg config.AudioSettings, sampleRate, channels int) *audio.Meter {
+	rate := cfg.MeterRate
+	if rate <= 0 || rate > maxMeterRate {
+		slogger.Log.Warn("Meter rate out of range, using default", "meter_rate", rate, "default", defaultMeterRate)
+		rate = defaultMeterRate
+	}
+	hold := time.Duration(max(cfg.MeterHoldMs, 0)) * time.Millisecond
+	return audio.NewMeter(sampleRate, channels, time.Second/time.Duration(rate), hold)
+}
+
+// updateMeter feeds a captured block to the level meter. Each completed
+// reading updates MasterPeak, without a status broadcast, and is pushed as a
+// meter_update to the clients subscribed to meters.
+func (m *Manager) updateMeter(samples []float32) {
+	m.meter.Write(samples, func(peak, rms, hold []float64) {
+		master := audio.SilenceFloorDB
+		for _, p := range peak {
+			master = max(master, p)
+		}
+		m.statusMux.Lock()
+		m.status.MasterPeak = master
+		m.statusMux.Unlock()
+
+		if !websocket.HasSubscribers(websocket.TopicMeters) {
+			return
+		}
+		update := common.MeterUpdate{
+			Time:     time.Now(),
+			Channels: make([]common.ChannelLevel, len(peak)),
+		}
+		for c := range peak {
+			update.Channels[c] = common.ChannelLevel{Peak: peak[c], RMS: rms[c], PeakHold: hold[c]}
+		}
+		websocket.Publish(websocket.TopicMeters, "meter_update", update)
+	})
+}
@@ -0,0 +1,138 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/db"
+	"nixon/internal/peaks"
+	"nixon/internal/slogger"
+)
+
+// peaksSuffix names the waveform cache kept next to each recording file.
+const peaksSuffix = ".peaks"
+
+// ErrInvalidResolution is returned when peaks are requested at a resolution
+// that cannot be derived from the cached data.
+var ErrInvalidResolution = errors.New("invalid peaks resolution")
+
+// GetPeaks returns the waveform of a recording at samplesPerPixel, which
+// must be peaks.BaseSamplesPerPixel times a power of two up to
+// peaks.MaxSamplesPerPixel. Peaks are computed while recording; for older
+// files they are computed from the audio on first request and cached. The
+// take being recorded returns the peaks captured so far.
+func (m *Manager) GetPeaks(id uint, samplesPerPixel int) (*peaks.Peaks, error) {
+	if samplesPerPixel < peaks.BaseSamplesPerPixel || samplesPerPixel > peaks.MaxSamplesPerPixel ||
+		samplesPerPixel%peaks.BaseSamplesPerPixel != 0 || !isPowerOfTwo(samplesPerPixel/peaks.BaseSamplesPerPixel) {
+		return nil, fmt.Errorf("%w: %d samples per pixel", ErrInvalidResolution, samplesPerPixel)
+	}
+
+	m.recMux.Lock()
+	if m.recorder != nil && m.recorder.rec.ID == id {
+		p := m.recorder.peaks.Peaks()
+		m.recMux.Unlock()
+		return p.Resample(samplesPerPixel)
+	}
+	m.recMux.Unlock()
+
+	rec, err := db.GetRecordingByID(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := m.recordingPeaks(rec)
+	if err != nil {
+		return nil, err
+	}
+	return p.Resample(samplesPerPixel)
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// recordingPeaks loads the cached peaks of a finished recording, computing
+// and caching them if they are missing or do not match the file.
+func (m *Manager) recordingPeaks(rec *common.Recording) (*peaks.Peaks, error) {
+	// Only one recording is decoded at a time; a second request for the same
+	// one then finds the cache.
+	m.peaksMux.Lock()
+	defer m.peaksMux.Unlock()
+
+	path := filepath.Join(config.AppConfig.Audio.RecordingsDir, rec.Filename)
+	src, info, err := openRecordingAudio(rec)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: audio file is missing", ErrRecordingNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening recording: %w", err)
+	}
+	defer src.Close()
+
+	if p, err := readPeaks(path + peaksSuffix); err == nil && peaksMatch(p, info) {
+		return p, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		slogger.Log.Warn("Discarding unreadable waveform cache", "err", err, "file", rec.Filename)
+	}
+
+	b := peaks.NewBuilder(info.SampleRate, info.Channels)
+	buf := make([]float32, 8192*info.Channels)
+	for {
+		n, err := src.ReadFrames(buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding recording: %w", err)
+		}
+		b.Write(buf[:n*info.Channels])
+	}
+	p := b.Peaks()
+	if err := writePeaks(path+peaksSuffix, p); err != nil {
+		slogger.Log.Warn("Failed to cache waveform", "err", err, "file", rec.Filename)
+	}
+	return p, nil
+}
+
+// peaksMatch reports whether cached peaks describe audio of the given format and length.
+func peaksMatch(p *peaks.Peaks, info audioInfo) bool {
+	pixels := (info.Frames + peaks.BaseSamplesPerPixel - 1) / peaks.BaseSamplesPerPixel
+	return p.SampleRate == info.SampleRate && p.Channels == info.Channels &&
+		p.SamplesPerPixel == peaks.BaseSamplesPerPixel && int64(p.Length()) == pixels
+}
+
+func readPeaks(path string) (*peaks.Peaks, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return peaks.ReadBinary(f)
+}
+
+// writePeaks stores peaks at full resolution, replacing the file atomically.
+func writePeaks(path string, p *peaks.Peaks) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = p.WriteBinary(f, 16)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
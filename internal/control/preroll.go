@@ -0,0 +1,65 @@
+package control
+
+// maxPreRollSecs caps the pre-roll buffer so it cannot grow without bound.
+const maxPreRollSecs = 30
+
+// ringBuffer holds the most recent interleaved samples, overwriting the oldest
+// once full. Its capacity is a whole number of frames, and it is only ever
+// written in whole frames, so its contents always start on a frame boundary.
+type ringBuffer struct {
+	buf   []float32
+	start int // index of the oldest sample
+	size  int // number of valid samples
+}
+
+// newRingBuffer creates a buffer holding up to frames frames of channels samples.
+func newRingBuffer(frames, channels int) *ringBuffer {
+	return &ringBuffer{buf: make([]float32, frames*channels)}
+}
+
+// write appends samples, discarding the oldest audio if the buffer overflows.
+func (r *ringBuffer) write(samples []float32) {
+	c := len(r.buf)
+	if c == 0 {
+		return
+	}
+	if len(samples) >= c {
+		copy(r.buf, samples[len(samples)-c:])
+		r.start, r.size = 0, c
+		return
+	}
+
+	end := (r.start + r.size) % c
+	n := copy(r.buf[end:], samples)
+	copy(r.buf, samples[n:])
+
+	r.size += len(samples)
+	if r.size > c {
+		r.start = (r.start + r.size - c) % c
+		r.size = c
+	}
+}
+
+// drain passes the buffered samples to fn oldest first, in at most two
+// contiguous slices, and empties the buffer.
+func (r *ringBuffer) drain(fn func([]float32) error) error {
+	defer r.reset()
+
+	c := len(r.buf)
+	if r.size == 0 {
+		return nil
+	}
+	if r.start+r.size <= c {
+		return fn(r.buf[r.start : r.start+r.size])
+	}
+	if err := fn(r.buf[r.start:]); err != nil {
+		return err
+	}
+	return fn(r.buf[:r.start+r.size-c])
+}
+
+// len returns the number of buffered samples.
+func (r *ringBuffer) len() int { return r.size }
+
+// reset empties the buffer.
+func (r *ringBuffer) reset() { r.start, r.size = 0, 0 }
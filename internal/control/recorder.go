@@ -0,0 +1,256 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/db"
+	"nixon/internal/loudness"
+	"nixon/internal/peaks"
+	"nixon/internal/slogger"
+	"nixon/internal/websocket"
+)
+
+// headerSyncInterval is how often the file header is rewritten and flushed,
+// bounding how much audio a power loss can leave outside the header.
+const headerSyncInterval = time.Second
+
+// recorder writes a single take to disk and tracks its database row.
+type recorder struct {
+	rec        *common.Recording
+	path       string
+	writer     takeWriter
+	peaks      *peaks.Builder
+	loudness   *loudness.Meter
+	sampleRate int
+	channels   int
+	maxFrames  int64 // length at which the take rolls over to a new part
+	lastSync   time.Time
+	auto       bool      // started by the activity detector, which may still end it
+	stopFrom   int64     // frame count when the post-roll was scheduled
+	stopAt     int64     // frame count at which a pending post-roll ends, 0 if none
+	pausedAt   time.Time // zero unless the take is paused
+}
+
+// newRecorder creates the audio file and database row for a new take. rec
+// carries the StartTime of the first sample and, for continuation parts, the
+// session and format it belongs to; its Filename and ID are filled in, and
+// its Format too if it was not set.
+func newRecorder(dir string, rec *common.Recording, sampleRate, channels int) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating recordings directory: %w", err)
+	}
+
+	if rec.Format == "" {
+		rec.Format = strings.ToLower(config.AppConfig.Audio.Format)
+	}
+	filename, writer, err := createTakeFile(dir, rec.StartTime, rec.Format, sampleRate, channels)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, filename)
+
+	rec.Filename = filename
+	if rec.Part == 0 {
+		rec.Part = 1
+	}
+	if err := db.CreateRecording(rec); err != nil {
+		writer.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("adding recording to database: %w", err)
+	}
+	websocket.Publish(websocket.TopicRecordings, "recording_created", rec)
+
+	return &recorder{
+		rec:        rec,
+		path:       path,
+		writer:     writer,
+		peaks:      peaks.NewBuilder(sampleRate, channels),
+		loudness:   loudness.NewMeter(sampleRate, channels),
+		sampleRate: sampleRate,
+		channels:   channels,
+		maxFrames:  maxTakeFrames(rec.Format, sampleRate, channels),
+		lastSync:   time.Now(),
+	}, nil
+}
+
+// maxTakeFrames returns the length at which a take is split: the configured
+// maximum recording time, capped by what a single file of the format can hold.
+func maxTakeFrames(format string, sampleRate, channels int) int64 {
+	limit := maxFormatFrames(format, channels)
+	if mins := config.AppConfig.AutoRec.MaxRecordMins; mins > 0 {
+		limit = min(limit, int64(mins)*60*int64(sampleRate))
+	}
+	return limit
+}
+
+// createTakeFile picks a timestamped filename that does not exist yet and creates it.
+func createTakeFile(dir string, start time.Time, format string, sampleRate, channels int) (string, takeWriter, error) {
+	base := "nixon_" + start.Format("20060102_150405")
+	for i := 0; ; i++ {
+		filename := base + "." + format
+		if i > 0 {
+			filename = fmt.Sprintf("%s_%d.%s", base, i, format)
+		}
+		writer, err := createTake(filepath.Join(dir, filename), format, sampleRate, channels)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("creating recording file: %w", err)
+		}
+		return filename, writer, nil
+	}
+}
+
+// next finalizes r and starts the following part of the same session. The
+// new part begins exactly where r ended and inherits its auto-record state,
+// including any post-roll still pending.
+func (r *recorder) next(dir string) (*recorder, error) {
+	prev, err := r.finish()
+	if err != nil {
+		slogger.Log.Error("Failed to finalize recording part", "err", err, "file", prev.Filename)
+	}
+
+	sessionID := prev.SessionID
+	if sessionID == 0 {
+		sessionID = prev.ID
+	}
+	n, err := newRecorder(dir, &common.Recording{
+		StartTime: prev.StartTime.Add(prev.Duration),
+		SessionID: sessionID,
+		Part:      prev.Part + 1,
+		Format:    prev.Format,
+	}, r.sampleRate, r.channels)
+	if err != nil {
+		return nil, err
+	}
+
+	n.auto = r.auto
+	if remaining := r.stopAt - r.writer.Frames(); r.stopAt > 0 && remaining > 0 {
+		n.stopAt = remaining
+	}
+	return n, nil
+}
+
+// write appends a block of interleaved samples, adds it to the waveform and
+// loudness measurement and periodically syncs the header.
+func (r *recorder) write(samples []float32) error {
+	if err := r.writer.WriteFrames(samples); err != nil {
+		return err
+	}
+	r.peaks.Write(samples)
+	r.loudness.Write(samples)
+	if time.Since(r.lastSync) >= headerSyncInterval {
+		r.lastSync = time.Now()
+		return r.writer.Sync()
+	}
+	return nil
+}
+
+// schedulePostRoll arranges for the take to end after d more audio.
+func (r *recorder) schedulePostRoll(d time.Duration) {
+	r.stopFrom = r.writer.Frames()
+	r.stopAt = r.stopFrom + int64(d.Seconds()*float64(r.sampleRate))
+}
+
+// cancelPostRoll drops a pending post-roll and reports whether there was one.
+func (r *recorder) cancelPostRoll() bool {
+	pending := r.stopAt > 0
+	r.stopFrom, r.stopAt = 0, 0
+	return pending
+}
+
+// paused reports whether captured audio is currently being left out of the take.
+func (r *recorder) paused() bool { return !r.pausedAt.IsZero() }
+
+// pause stops writing captured audio to the take. Pausing hands a take the
+// activity detector started over to the user: any pending post-roll is
+// dropped and the detector no longer ends it, paused or after resuming.
+func (r *recorder) pause() {
+	r.pausedAt = time.Now()
+	r.auto = false
+	r.cancelPostRoll()
+}
+
+// resume continues the take where it left off and returns how long it was paused.
+func (r *recorder) resume() time.Duration {
+	gap := time.Since(r.pausedAt)
+	r.pausedAt = time.Time{}
+	return gap
+}
+
+// postRollDone reports whether a pending post-roll has been fully written.
+func (r *recorder) postRollDone() bool {
+	return r.stopAt > 0 && r.writer.Frames() >= r.stopAt
+}
+
+// duration returns the length of audio written so far.
+func (r *recorder) duration() time.Duration {
+	return time.Duration(r.writer.Frames()) * time.Second / time.Duration(r.sampleRate)
+}
+
+// finish embeds the take's markers and metadata, closes the file, caches its
+// waveform and records its final length, size and loudness in the database.
+func (r *recorder) finish() (*common.Recording, error) {
+	r.rec.EndTime = time.Now()
+	r.rec.Duration = r.duration()
+	if r.stopAt > 0 {
+		r.rec.PostRoll = time.Duration(r.writer.Frames()-r.stopFrom) * time.Second / time.Duration(r.sampleRate)
+	}
+	setLoudness(r.rec, r.loudness.Result())
+	r.embedMarkers()
+	r.writer.setMetadata(r.rec)
+	closeErr := r.writer.Close()
+	if closeErr == nil {
+		if err := writePeaks(r.path+peaksSuffix, r.peaks.Peaks()); err != nil {
+			slogger.Log.Warn("Failed to cache waveform", "err", err, "file", r.rec.Filename)
+		}
+	}
+
+	r.rec.FileSize = r.writer.Size()
+	if err := db.FinalizeRecording(r.rec); err != nil {
+		return r.rec, fmt.Errorf("finalizing recording in database: %w", err)
+	}
+	websocket.Publish(websocket.TopicRecordings, "recording_updated", r.rec)
+	if closeErr != nil {
+		return r.rec, fmt.Errorf("closing recording file: %w", closeErr)
+	}
+	return r.rec, nil
+}
+
+// recoverUnfinished repairs the files of takes interrupted by a crash and
+// finalizes their database rows from what made it to disk.
+func (m *Manager) recoverUnfinished(dir string) {
+	recordings, err := db.GetUnfinishedRecordings()
+	if err != nil {
+		slogger.Log.Error("Failed to look up unfinished recordings", "err", err)
+		return
+	}
+
+	for _, rec := range recordings {
+		path := filepath.Join(dir, rec.Filename)
+		repaired, duration, size, err := repairTake(path, rec.Format)
+		if err != nil {
+			slogger.Log.Error("Failed to repair unfinished recording", "err", err, "file", rec.Filename)
+			continue
+		}
+		rec.EndTime = rec.StartTime.Add(duration)
+		rec.Duration = duration
+		rec.FileSize = size
+		if err := db.FinalizeRecording(&rec); err != nil {
+			slogger.Log.Error("Failed to finalize unfinished recording", "err", err, "id", rec.ID)
+			continue
+		}
+		if err := m.writeFileMetadata(&rec); err != nil {
+			slogger.Log.Warn("Failed to add metadata to recovered recording", "err", err, "file", rec.Filename)
+		}
+		websocket.Publish(websocket.TopicRecordings, "recording_updated", &rec)
+		slogger.Log.Warn("Recovered unfinished recording", "id", rec.ID, "file", rec.Filename, "repaired", repaired, "duration", duration)
+	}
+}
@@ -0,0 +1,210 @@
+package control
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/db"
+	"nixon/internal/slogger"
+	"nixon/internal/websocket"
+)
+
+// deletingSuffix marks a recording file whose database row is being deleted.
+const deletingSuffix = ".deleting"
+
+var (
+	// ErrRecordingNotFound is returned when no recording has the requested ID.
+	ErrRecordingNotFound = errors.New("recording not found")
+	// ErrRecordingInUse is returned when deleting the take currently being written.
+	ErrRecordingInUse = errors.New("recording is currently being written")
+)
+
+// GetRecordings returns the page of recordings selected by q and the total
+// number matching its filters. Parts of a split take can be grouped by their
+// SessionID, or listed alone with q.Session.
+func (m *Manager) GetRecordings(q common.RecordingQuery) ([]common.Recording, int64, error) {
+	return db.QueryRecordings(q)
+}
+
+// GetRecording returns a single recording.
+func (m *Manager) GetRecording(id uint) (*common.Recording, error) {
+	rec, err := db.GetRecordingByID(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrRecordingNotFound
+	}
+	return rec, err
+}
+
+// UpdateRecording applies a partial metadata update, which is also written
+// to the recording's file.
+func (m *Manager) UpdateRecording(id uint, update common.RecordingUpdate) (*common.Recording, error) {
+	rec, err := db.PatchRecording(id, update)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrRecordingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.metadataChanged(rec)
+	return rec, nil
+}
+
+// metadataChanged propagates edited metadata to the other places it is kept:
+// the live recorder for the take being written, so it is embedded when the
+// take is finalized, or the metadata chunks of a finished file. Clients
+// are told about the change either way, once the file and its size are
+// up to date.
+func (m *Manager) metadataChanged(rec *common.Recording) {
+	m.recMux.Lock()
+	live := m.recorder != nil && m.recorder.rec.ID == rec.ID
+	if live {
+		r := m.recorder.rec
+		r.Notes = rec.Notes
+		r.Genre = rec.Genre
+		r.Favorite = rec.Favorite
+		r.Rating = rec.Rating
+		r.Tags = rec.Tags
+	}
+	m.recMux.Unlock()
+
+	if !live {
+		if err := m.writeFileMetadata(rec); err != nil {
+			slogger.Log.Error("Failed to rewrite recording metadata in file", "err", err, "file", rec.Filename)
+		}
+	}
+	websocket.Publish(websocket.TopicRecordings, "recording_updated", rec)
+}
+
+// reloadMetadata propagates the metadata of the recordings in ids after an
+// edit made directly in the database.
+func (m *Manager) reloadMetadata(ids []uint) {
+	for _, id := range slices.Compact(slices.Sorted(slices.Values(ids))) {
+		rec, err := db.GetRecordingByID(id)
+		if err != nil {
+			slogger.Log.Warn("Failed to reload recording metadata", "err", err, "id", id)
+			continue
+		}
+		m.metadataChanged(rec)
+	}
+}
+
+// GetTags returns the tags in use with their recording counts.
+func (m *Manager) GetTags() ([]common.Tag, error) {
+	return db.GetTags()
+}
+
+// TagRecordings attaches tags to each of the recordings in ids.
+func (m *Manager) TagRecordings(ids []uint, tags []string) error {
+	err := db.AddTags(ids, tags)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrRecordingNotFound
+	}
+	if err == nil {
+		m.reloadMetadata(ids)
+	}
+	return err
+}
+
+// UntagRecordings detaches tags from each of the recordings in ids.
+func (m *Manager) UntagRecordings(ids []uint, tags []string) error {
+	err := db.RemoveTags(ids, tags)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrRecordingNotFound
+	}
+	if err == nil {
+		m.reloadMetadata(ids)
+	}
+	return err
+}
+
+// DeleteRecording removes a recording's database row and audio file together,
+// along with its markers, waveform and exports.
+// The file is moved aside inside the database transaction and only removed
+// once the row deletion has committed, so a failure at any point leaves both
+// in place. The take currently being written cannot be deleted.
+func (m *Manager) DeleteRecording(id uint) error {
+	m.recMux.Lock()
+	active := m.recorder != nil && m.recorder.rec.ID == id
+	m.recMux.Unlock()
+	if active {
+		return ErrRecordingInUse
+	}
+
+	exports, err := db.GetExportJobs(id)
+	if err != nil {
+		return err
+	}
+
+	dir := config.AppConfig.Audio.RecordingsDir
+	var path, moved string
+	var deleted common.Recording
+	err = db.DeleteRecordingWith(id, func(rec *common.Recording) error {
+		deleted = *rec
+		path = filepath.Join(dir, rec.Filename)
+		if err := os.Rename(path, path+deletingSuffix); err != nil {
+			if os.IsNotExist(err) {
+				slogger.Log.Warn("Recording file already missing, deleting row only", "id", id, "file", rec.Filename)
+				return nil
+			}
+			return err
+		}
+		moved = path + deletingSuffix
+		return nil
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrRecordingNotFound
+	}
+	if err != nil {
+		if moved != "" {
+			if rerr := os.Rename(moved, path); rerr != nil {
+				slogger.Log.Error("Failed to restore recording file after aborted delete", "err", rerr, "file", path)
+			}
+		}
+		return err
+	}
+
+	if moved != "" {
+		if err := os.Remove(moved); err != nil {
+			slogger.Log.Warn("Failed to remove deleted recording file", "err", err, "file", moved)
+		}
+	}
+	if err := os.Remove(path + peaksSuffix); err != nil && !os.IsNotExist(err) {
+		slogger.Log.Warn("Failed to remove waveform cache", "err", err, "file", filepath.Base(path))
+	}
+	m.exports.cancel(func(job *common.ExportJob) bool { return job.RecordingID == id })
+	removeExportFiles(exports)
+	slogger.Log.Info("Recording deleted", "id", id, "file", filepath.Base(path))
+	websocket.Publish(websocket.TopicRecordings, "recording_deleted", &deleted)
+	return nil
+}
+
+// recoverDeletes finishes or rolls back deletes interrupted by a crash: a file
+// whose row still exists is put back, otherwise it is removed.
+func recoverDeletes(dir string) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+deletingSuffix))
+	if err != nil {
+		slogger.Log.Error("Failed to scan for interrupted deletes", "err", err)
+		return
+	}
+
+	for _, moved := range matches {
+		path := strings.TrimSuffix(moved, deletingSuffix)
+		_, err := db.GetRecordingByFilename(filepath.Base(path))
+		switch {
+		case err == nil:
+			err = os.Rename(moved, path)
+			slogger.Log.Warn("Restored recording from interrupted delete", "file", filepath.Base(path), "err", err)
+		case errors.Is(err, db.ErrNotFound):
+			err = os.Remove(moved)
+			slogger.Log.Warn("Completed interrupted delete", "file", filepath.Base(path), "err", err)
+		default:
+			slogger.Log.Error("Failed to look up recording for interrupted delete", "err", err, "file", filepath.Base(path))
+		}
+	}
+}
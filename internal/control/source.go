@@ -0,0 +1,30 @@
+package control
+
+import (
+	"fmt"
+
+	"nixon/internal/audio"
+	"nixon/internal/config"
+)
+
+// newSource builds the audio source selected by the audio configuration.
+func newSource(cfg config.AudioSettings) (audio.Source, error) {
+	src := cfg.Source
+	switch src.Type {
+	case "", "silence":
+		return audio.NewSilence(cfg.SampleRate, cfg.Channels), nil
+	case "tone":
+		return audio.NewGenerator(cfg.SampleRate, cfg.Channels, audio.WaveSine, src.Frequency, src.Level)
+	case "noise":
+		return audio.NewGenerator(cfg.SampleRate, cfg.Channels, audio.WaveNoise, src.Frequency, src.Level)
+	case "file":
+		if src.File == "" {
+			return nil, fmt.Errorf("audio source type %q requires audio.source.file", src.Type)
+		}
+		return audio.NewFile(src.File, src.Loop)
+	case "script":
+		return audio.NewScript(cfg.SampleRate, cfg.Channels, src.Script, src.Frequency, src.Level)
+	default:
+		return nil, fmt.Errorf("unknown audio source type %q", src.Type)
+	}
+}
@@ -0,0 +1,108 @@
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/websocket"
+)
+
+// maxStateHistory bounds the in-memory transition log.
+const maxStateHistory = 200
+
+// stateEvent names something that moves the recorder between states.
+type stateEvent string
+
+const (
+	eventArm       stateEvent = "arm"
+	eventDisarm    stateEvent = "disarm"
+	eventStart     stateEvent = "start"
+	eventPause     stateEvent = "pause"
+	eventResume    stateEvent = "resume"
+	eventStop      stateEvent = "stop"
+	eventFinalized stateEvent = "finalized"
+)
+
+// transitions lists, for each event, the states it is accepted in and the
+// state it leads to:
+//
+//	stopped           --arm-->       armed
+//	armed             --disarm-->    stopped
+//	stopped, armed    --start-->     recording
+//	recording         --pause-->     paused
+//	paused            --resume-->    recording
+//	recording, paused --stop-->      finalizing
+//	finalizing        --finalized--> stopped (then re-armed if auto-record is on)
+//
+// Streaming is tracked separately in ActiveStreams and is independent of these states.
+var transitions = map[stateEvent]map[common.AudioState]common.AudioState{
+	eventArm:       {common.StateStopped: common.StateArmed},
+	eventDisarm:    {common.StateArmed: common.StateStopped},
+	eventStart:     {common.StateStopped: common.StateRecording, common.StateArmed: common.StateRecording},
+	eventPause:     {common.StateRecording: common.StatePaused},
+	eventResume:    {common.StatePaused: common.StateRecording},
+	eventStop:      {common.StateRecording: common.StateFinalizing, common.StatePaused: common.StateFinalizing},
+	eventFinalized: {common.StateFinalizing: common.StateStopped},
+}
+
+// TransitionError is returned when an operation is not allowed in the
+// current state, e.g. pausing when nothing is being recorded.
+type TransitionError struct {
+	Event string
+	From  common.AudioState
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot %s while %s", e.Event, e.From)
+}
+
+// checkTransition reports whether ev is allowed in the current state without applying it.
+func (m *Manager) checkTransition(ev stateEvent) error {
+	m.statusMux.RLock()
+	defer m.statusMux.RUnlock()
+
+	if _, ok := transitions[ev][m.status.State]; !ok {
+		return &TransitionError{Event: string(ev), From: m.status.State}
+	}
+	return nil
+}
+
+// transition applies ev to the state machine, lets fn adjust the rest of the
+// status in the same update, records the change and broadcasts it.
+func (m *Manager) transition(ev stateEvent, fn func(*common.AudioStatus)) error {
+	m.statusMux.Lock()
+	from := m.status.State
+	to, ok := transitions[ev][from]
+	if !ok {
+		m.statusMux.Unlock()
+		return &TransitionError{Event: string(ev), From: from}
+	}
+
+	m.status.State = to
+	if fn != nil {
+		fn(&m.status)
+	}
+	m.recordTransitionLocked(common.StateTransition{From: from, To: to, Event: string(ev), Time: time.Now()})
+	newStatus := m.status
+	m.statusMux.Unlock()
+
+	websocket.BroadcastStatus(newStatus)
+	return nil
+}
+
+// recordTransitionLocked appends t to the history, dropping the oldest entry
+// when full. statusMux must be held.
+func (m *Manager) recordTransitionLocked(t common.StateTransition) {
+	if len(m.history) >= maxStateHistory {
+		m.history = append(m.history[:0], m.history[1:]...)
+	}
+	m.history = append(m.history, t)
+}
+
+// GetStateHistory returns the most recent state transitions, oldest first.
+func (m *Manager) GetStateHistory() []common.StateTransition {
+	m.statusMux.RLock()
+	defer m.statusMux.RUnlock()
+	return append([]common.StateTransition(nil), m.history...)
+}
@@ -0,0 +1,73 @@
+package control
+
+import (
+	"errors"
+	"testing"
+
+	"nixon/internal/common"
+)
+
+func TestTransitions(t *testing.T) {
+	states := []common.AudioState{
+		common.StateStopped, common.StateArmed, common.StateRecording,
+		common.StatePaused, common.StateFinalizing, common.StateStreaming,
+	}
+	// allowed maps each event to the states it is accepted in and where it
+	// leads; every other combination must be rejected.
+	allowed := map[stateEvent]map[common.AudioState]common.AudioState{
+		eventArm:       {common.StateStopped: common.StateArmed},
+		eventDisarm:    {common.StateArmed: common.StateStopped},
+		eventStart:     {common.StateStopped: common.StateRecording, common.StateArmed: common.StateRecording},
+		eventPause:     {common.StateRecording: common.StatePaused},
+		eventResume:    {common.StatePaused: common.StateRecording},
+		eventStop:      {common.StateRecording: common.StateFinalizing, common.StatePaused: common.StateFinalizing},
+		eventFinalized: {common.StateFinalizing: common.StateStopped},
+	}
+	if len(allowed) != len(transitions) {
+		t.Fatalf("transition table has %d events, test covers %d", len(transitions), len(allowed))
+	}
+
+	for ev, to := range allowed {
+		for _, from := range states {
+			m := &Manager{status: common.AudioStatus{State: from}}
+			want, ok := to[from]
+			checkErr := m.checkTransition(ev)
+			err := m.transition(ev, nil)
+			state := m.GetStatus().State
+			history := m.GetStateHistory()
+
+			if ok {
+				if checkErr != nil || err != nil || state != want {
+					t.Errorf("%s from %s: check %v, transition %v, now %s; want %s", ev, from, checkErr, err, state, want)
+					continue
+				}
+				if len(history) != 1 || history[0].From != from || history[0].To != want || history[0].Event != string(ev) {
+					t.Errorf("%s from %s recorded %+v", ev, from, history)
+				}
+				continue
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || te.Event != string(ev) || te.From != from || !errors.As(checkErr, &te) {
+				t.Errorf("%s from %s: check %v, transition %v; want a TransitionError", ev, from, checkErr, err)
+			}
+			if state != from || len(history) != 0 {
+				t.Errorf("rejected %s from %s changed the state to %s and recorded %+v", ev, from, state, history)
+			}
+		}
+	}
+}
+
+func TestStateHistoryIsBounded(t *testing.T) {
+	m := &Manager{status: common.AudioStatus{State: common.StateStopped}}
+	for range maxStateHistory {
+		m.transition(eventArm, nil)
+		m.transition(eventDisarm, nil)
+	}
+	history := m.GetStateHistory()
+	if len(history) != maxStateHistory {
+		t.Fatalf("history holds %d transitions, want %d", len(history), maxStateHistory)
+	}
+	if last := history[len(history)-1]; last.Event != string(eventDisarm) {
+		t.Fatalf("newest transition = %+v, want the last disarm", last)
+	}
+}
@@ -0,0 +1,95 @@
+package control
+
+import (
+	"errors"
+	"time"
+
+	"nixon/internal/audio"
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/slogger"
+)
+
+// newVAD builds the activity detector from the auto-record settings.
+func newVAD(cfg config.AutoRecord, sampleRate, channels int) (*audio.VAD, error) {
+	return audio.NewVAD(audio.VADConfig{
+		SampleRate:   sampleRate,
+		Channels:     channels,
+		Mode:         audio.VADMode(cfg.VADMode),
+		ThresholdDB:  vadThresholdDB(cfg.VADThreshold),
+		HysteresisDB: cfg.VADHysteresis,
+		Attack:       time.Duration(cfg.VADAttackMs) * time.Millisecond,
+		Release:      time.Duration(cfg.VADGraceTime) * time.Second,
+	})
+}
+
+// vadThresholdDB interprets the configured threshold. Older configs stored a
+// linear amplitude in (0, 1]; anything positive is converted to dBFS.
+func vadThresholdDB(threshold float64) float64 {
+	if threshold > 0 {
+		return audio.AmplitudeToDB(threshold)
+	}
+	return threshold
+}
+
+// detectActivity feeds a block to the detector and, when auto-record is
+// enabled, starts or stops a take on each transition.
+func (m *Manager) detectActivity(samples []float32) {
+	if !m.vad.Process(samples) {
+		return
+	}
+
+	active := m.vad.Active()
+	rmsDB, peakDB := m.vad.Level()
+	slogger.Log.Debug("Voice activity changed", "active", active, "rms_db", rmsDB, "peak_db", peakDB)
+	m.updateStatus(func(s *common.AudioStatus) {
+		s.VADStatus = active
+		s.LastVADEvent = time.Now()
+	})
+
+	if !config.AppConfig.AutoRec.Enabled {
+		return
+	}
+	if active {
+		if m.continueAutoRecording() {
+			return
+		}
+		var te *TransitionError
+		if err := m.startRecording(true); err != nil && !errors.As(err, &te) {
+			slogger.Log.Error("Auto-record failed to start recording", "err", err)
+		}
+		return
+	}
+	m.stopAutoRecording()
+}
+
+// continueAutoRecording cancels a pending post-roll when activity resumes
+// before it ends, so the take carries on. It reports whether it did so.
+func (m *Manager) continueAutoRecording() bool {
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
+
+	if m.recorder == nil || !m.recorder.auto || !m.recorder.cancelPostRoll() {
+		return false
+	}
+	slogger.Log.Info("Auto-record: activity resumed during post-roll, continuing", "file", m.recorder.rec.Filename)
+	return true
+}
+
+// stopAutoRecording stops the active take if the detector started it, after
+// the configured post-roll. Takes started by hand are left running.
+func (m *Manager) stopAutoRecording() {
+	m.recMux.Lock()
+	defer m.recMux.Unlock()
+
+	if m.recorder == nil || !m.recorder.auto {
+		return
+	}
+	if postRoll := time.Duration(config.AppConfig.AutoRec.PostRollSecs) * time.Second; postRoll > 0 {
+		slogger.Log.Info("Auto-record: silence detected, recording post-roll", "file", m.recorder.rec.Filename, "post_roll", postRoll)
+		m.recorder.schedulePostRoll(postRoll)
+		return
+	}
+	slogger.Log.Info("Auto-record: silence detected, stopping recording", "file", m.recorder.rec.Filename)
+	m.finishRecordingLocked()
+}
@@ -0,0 +1,74 @@
+package control
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+)
+
+// tone returns a 20 ms mono block of a 440 Hz sine with the given peak amplitude.
+func tone(sampleRate int, amplitude float64) []float32 {
+	block := make([]float32, sampleRate/50)
+	for i := range block {
+		block[i] = float32(amplitude * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate)))
+	}
+	return block
+}
+
+func TestDetectActivityUpdatesStatus(t *testing.T) {
+	const sampleRate = 8000
+	vad, err := newVAD(config.AutoRecord{VADThreshold: -40, VADAttackMs: 40, VADGraceTime: 1}, sampleRate, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{vad: vad, status: common.AudioStatus{State: common.StateStopped}}
+	loud, quiet := tone(sampleRate, 0.5), tone(sampleRate, 0.001)
+
+	// The first loud block is still within the attack time.
+	m.detectActivity(loud)
+	if s := m.GetStatus(); s.VADStatus || !s.LastVADEvent.IsZero() {
+		t.Fatalf("status after one block = %t at %v, want no event yet", s.VADStatus, s.LastVADEvent)
+	}
+
+	before := time.Now()
+	m.detectActivity(loud)
+	active := m.GetStatus()
+	if !active.VADStatus || active.LastVADEvent.Before(before) {
+		t.Fatalf("status after attack = %t at %v, want active since %v", active.VADStatus, active.LastVADEvent, before)
+	}
+
+	// Silence shorter than the one second grace time changes nothing.
+	for range 49 {
+		m.detectActivity(quiet)
+	}
+	if s := m.GetStatus(); !s.VADStatus || !s.LastVADEvent.Equal(active.LastVADEvent) {
+		t.Fatalf("status within grace time = %t at %v, want still active since %v", s.VADStatus, s.LastVADEvent, active.LastVADEvent)
+	}
+
+	m.detectActivity(quiet)
+	if s := m.GetStatus(); s.VADStatus || !s.LastVADEvent.After(active.LastVADEvent) {
+		t.Fatalf("status after grace time = %t at %v, want inactive after %v", s.VADStatus, s.LastVADEvent, active.LastVADEvent)
+	}
+	if s := m.GetStatus(); s.State != common.StateStopped {
+		t.Fatalf("state = %s with auto-record disabled, want %s", s.State, common.StateStopped)
+	}
+}
+
+func TestVADThresholdDB(t *testing.T) {
+	tests := []struct {
+		threshold, want float64
+	}{
+		{-40, -40},
+		{0, 0},
+		{0.01, -40},
+		{1, 0},
+	}
+	for _, tt := range tests {
+		if got := vadThresholdDB(tt.threshold); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("vadThresholdDB(%v) = %v, want %v", tt.threshold, got, tt.want)
+		}
+	}
+}
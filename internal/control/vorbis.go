@@ -0,0 +1,48 @@
+package control
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/wav"
+)
+
+// vorbisComments builds the Vorbis comments describing rec in a FLAC file.
+// The standard fields carry what players display; the NIXON_ fields carry the
+// library metadata, like the NIXON block of the iXML chunk in WAV files.
+func vorbisComments(rec *common.Recording, sampleRate int) []string {
+	comments := []string{
+		"TITLE=" + strings.TrimSuffix(rec.Filename, filepath.Ext(rec.Filename)),
+		"DATE=" + rec.StartTime.Format(time.RFC3339),
+		"ENCODED_BY=" + originator,
+		// Samples since midnight, as in the bext chunk, so DAWs can place the file on a timeline.
+		"TIME_REFERENCE=" + strconv.FormatUint(wav.TimeReferenceAt(rec.StartTime, sampleRate), 10),
+	}
+	if rec.Notes != "" {
+		comments = append(comments, "COMMENT="+rec.Notes)
+	}
+	if rec.Genre != "" {
+		comments = append(comments, "GENRE="+rec.Genre)
+	}
+
+	comments = append(comments, "NIXON_RECORDING_ID="+strconv.FormatUint(uint64(rec.ID), 10))
+	if rec.SessionID != 0 {
+		comments = append(comments, "NIXON_SESSION_ID="+strconv.FormatUint(uint64(rec.SessionID), 10))
+	}
+	if rec.Part != 0 {
+		comments = append(comments, "NIXON_PART="+strconv.Itoa(rec.Part))
+	}
+	if rec.Favorite {
+		comments = append(comments, "NIXON_FAVORITE=1")
+	}
+	if rec.Rating != 0 {
+		comments = append(comments, "NIXON_RATING="+strconv.Itoa(rec.Rating))
+	}
+	for _, t := range rec.Tags {
+		comments = append(comments, "NIXON_TAG="+t.Name)
+	}
+	return comments
+}
@@ -9,11 +9,15 @@ import (
 	"log/slog"
 	"nixon/internal/common" // IMPORT: Use canonical structs
 	"nixon/internal/slogger"
+	"strings"
 	"time"
 )
 
 var dbConn *gorm.DB
 
+// ErrNotFound is returned when a requested row does not exist.
+var ErrNotFound = gorm.ErrRecordNotFound
+
 // GormSlogger is a custom GORM logger that uses slog.
 type GormSlogger struct {
 	logger *slog.Logger
@@ -85,7 +89,7 @@ func Init(dsn string) error {
 	}
 
 	// Auto-migrate the database schema using the canonical struct
-	return dbConn.AutoMigrate(&common.Recording{})
+	return dbConn.AutoMigrate(&common.Recording{}, &common.Tag{}, &common.Marker{}, &common.ExportJob{})
 }
 
 // AddRecording creates a new recording entry in the database.
@@ -96,14 +100,22 @@ func AddRecording(filename string, startTime time.Time) (*common.Recording, erro
 		StartTime: startTime,
 		Notes:     "", // Default value
 		Genre:     "", // Default value
+		Part:      1,
 	}
-	result := dbConn.Create(rec)
-	if result.Error != nil {
-		return nil, result.Error
+	if err := CreateRecording(rec); err != nil {
+		return nil, err
 	}
 	return rec, nil
 }
 
+// CreateRecording inserts rec and fills in its ID.
+func CreateRecording(rec *common.Recording) error {
+	if dbConn == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbConn.Create(rec).Error
+}
+
 // UpdateRecording updates an existing recording in the database.
 func UpdateRecording(id uint, notes, genre string, endTime time.Time, duration time.Duration) error {
 	if dbConn == nil {
@@ -126,12 +138,239 @@ func UpdateRecording(id uint, notes, genre string, endTime time.Time, duration t
 	return dbConn.Save(&rec).Error
 }
 
+// PatchRecording applies a partial update of user-editable fields and returns
+// the updated recording.
+func PatchRecording(id uint, update common.RecordingUpdate) (*common.Recording, error) {
+	if dbConn == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var rec common.Recording
+	err := dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&rec, id).Error; err != nil {
+			return err
+		}
+		changes := map[string]interface{}{}
+		if update.Notes != nil {
+			changes["notes"] = *update.Notes
+		}
+		if update.Genre != nil {
+			changes["genre"] = *update.Genre
+		}
+		if update.Favorite != nil {
+			changes["favorite"] = *update.Favorite
+		}
+		if update.Rating != nil {
+			changes["rating"] = *update.Rating
+		}
+		if len(changes) > 0 {
+			if err := tx.Model(&rec).Updates(changes).Error; err != nil {
+				return err
+			}
+		}
+		if update.Tags != nil {
+			tags, err := findOrCreateTags(tx, *update.Tags)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&rec).Association("Tags").Replace(tags); err != nil {
+				return err
+			}
+			if err := pruneTags(tx); err != nil {
+				return err
+			}
+		}
+		return tx.Preload("Tags").First(&rec, id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// NormalizeTag returns the stored form of a tag name.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// findOrCreateTags returns the tags with the given names, creating any that
+// do not exist yet.
+func findOrCreateTags(tx *gorm.DB, names []string) ([]common.Tag, error) {
+	tags := make([]common.Tag, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = NormalizeTag(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		tag := common.Tag{Name: name}
+		if err := tx.Where("name = ?", name).FirstOrCreate(&tag).Error; err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// pruneTags removes tags no longer attached to any recording.
+func pruneTags(tx *gorm.DB) error {
+	return tx.Where("id NOT IN (SELECT tag_id FROM recording_tags)").Delete(&common.Tag{}).Error
+}
+
+// AddTags attaches the named tags to every recording in ids. It fails with
+// ErrNotFound, changing nothing, if any of the recordings does not exist.
+func AddTags(ids []uint, names []string) error {
+	if dbConn == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbConn.Transaction(func(tx *gorm.DB) error {
+		recs, err := findRecordings(tx, ids)
+		if err != nil {
+			return err
+		}
+		tags, err := findOrCreateTags(tx, names)
+		if err != nil || len(tags) == 0 {
+			return err
+		}
+		for i := range recs {
+			if err := tx.Model(&recs[i]).Association("Tags").Append(tags); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveTags detaches the named tags from every recording in ids. It fails
+// with ErrNotFound, changing nothing, if any of the recordings does not exist.
+func RemoveTags(ids []uint, names []string) error {
+	if dbConn == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = NormalizeTag(name)
+	}
+	return dbConn.Transaction(func(tx *gorm.DB) error {
+		if _, err := findRecordings(tx, ids); err != nil {
+			return err
+		}
+		err := tx.Exec("DELETE FROM recording_tags WHERE recording_id IN ? AND tag_id IN (SELECT id FROM tags WHERE name IN ?)",
+			ids, normalized).Error
+		if err != nil {
+			return err
+		}
+		return pruneTags(tx)
+	})
+}
+
+// findRecordings loads the recordings in ids, failing with ErrNotFound if any is missing.
+func findRecordings(tx *gorm.DB, ids []uint) ([]common.Recording, error) {
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	var recs []common.Recording
+	if err := tx.Find(&recs, ids).Error; err != nil {
+		return nil, err
+	}
+	if len(recs) != len(unique) {
+		return nil, ErrNotFound
+	}
+	return recs, nil
+}
+
+// GetTags returns every tag in use with the number of recordings carrying it,
+// most used first.
+func GetTags() ([]common.Tag, error) {
+	var tags []common.Tag
+	err := dbConn.Model(&common.Tag{}).
+		Select("tags.id, tags.name, COUNT(recording_tags.recording_id) AS count").
+		Joins("JOIN recording_tags ON recording_tags.tag_id = tags.id").
+		Group("tags.id").
+		Order("count DESC, tags.name").
+		Find(&tags).Error
+	return tags, err
+}
+
+// FinalizeRecording stores the fields of rec that are derived from the
+// captured audio. User-editable fields such as Notes and Genre are left alone.
+func FinalizeRecording(rec *common.Recording) error {
+	if dbConn == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbConn.Model(&common.Recording{ID: rec.ID}).
+		Select("StartTime", "EndTime", "Duration", "FileSize", "PreRoll", "PostRoll",
+			"Loudness", "LoudnessRange", "SamplePeak", "TruePeak").
+		Updates(rec).Error
+}
+
+// SetLoudness stores the loudness measurements of a recording.
+func SetLoudness(rec *common.Recording) error {
+	if dbConn == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbConn.Model(&common.Recording{ID: rec.ID}).
+		Select("Loudness", "LoudnessRange", "SamplePeak", "TruePeak").
+		Updates(rec).Error
+}
+
+// GetUnanalyzedRecordings returns the finished recordings that have no
+// loudness measurements yet, oldest first.
+func GetUnanalyzedRecordings() ([]common.Recording, error) {
+	var recordings []common.Recording
+	result := dbConn.Preload("Tags").
+		Where("loudness IS NULL AND end_time > ?", time.Time{}).
+		Order("id").Find(&recordings)
+	return recordings, result.Error
+}
+
+// SetFileSize stores the size of a recording's file after it was rewritten.
+func SetFileSize(id uint, size int64) error {
+	return dbConn.Model(&common.Recording{ID: id}).Update("file_size", size).Error
+}
+
 // DeleteRecording removes a recording from the database.
 func DeleteRecording(id uint) error {
 	result := dbConn.Delete(&common.Recording{}, id)
 	return result.Error
 }
 
+// DeleteRecordingWith removes a recording inside a transaction that only
+// commits if fn succeeds, so the row and whatever fn cleans up go together.
+func DeleteRecordingWith(id uint, fn func(rec *common.Recording) error) error {
+	return dbConn.Transaction(func(tx *gorm.DB) error {
+		var rec common.Recording
+		if err := tx.First(&rec, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Select("Tags").Delete(&rec).Error; err != nil {
+			return err
+		}
+		if err := pruneTags(tx); err != nil {
+			return err
+		}
+		if err := tx.Where("recording_id = ?", rec.ID).Delete(&common.Marker{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("recording_id = ?", rec.ID).Delete(&common.ExportJob{}).Error; err != nil {
+			return err
+		}
+		return fn(&rec)
+	})
+}
+
+// GetRecordingByFilename retrieves a single recording by its file name.
+func GetRecordingByFilename(filename string) (*common.Recording, error) {
+	var rec common.Recording
+	result := dbConn.Where("filename = ?", filename).First(&rec)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &rec, nil
+}
+
 // GetAllRecordings retrieves all recording entries.
 func GetAllRecordings() ([]common.Recording, error) {
 	var recordings []common.Recording
@@ -139,12 +378,267 @@ func GetAllRecordings() ([]common.Recording, error) {
 	return recordings, result.Error
 }
 
+// recordingSortColumns maps the sort keys accepted in a RecordingQuery to columns.
+var recordingSortColumns = map[string]string{
+	"id":        "id",
+	"startTime": "start_time",
+	"duration":  "duration",
+	"fileSize":  "file_size",
+	"filename":  "filename",
+	"genre":     "genre",
+	"part":      "part",
+	"rating":    "rating",
+
+	"loudness":      "loudness",
+	"loudnessRange": "loudness_range",
+	"samplePeak":    "sample_peak",
+	"truePeak":      "true_peak",
+}
+
+// nullableSortKeys are the sort keys whose columns are NULL until a take is
+// analyzed. Those rows are listed last in either direction.
+var nullableSortKeys = map[string]bool{
+	"loudness":      true,
+	"loudnessRange": true,
+	"samplePeak":    true,
+	"truePeak":      true,
+}
+
+// maxQueryLimit is the largest page of recordings a query returns, and the
+// page size of a query without a limit.
+const maxQueryLimit = 1000
+
+// likeEscaper escapes the LIKE wildcards in user-supplied search text.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// QueryRecordings returns the page of recordings selected by q and the total
+// number of recordings matching its filters. Results default to newest first,
+// or to part order when listing a session.
+func QueryRecordings(q common.RecordingQuery) ([]common.Recording, int64, error) {
+	tx := dbConn.Model(&common.Recording{})
+	if q.Session != 0 {
+		tx = tx.Where("id = ? OR session_id = ?", q.Session, q.Session)
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("start_time >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("start_time < ?", q.To)
+	}
+	if q.MinDuration > 0 {
+		tx = tx.Where("duration >= ?", q.MinDuration)
+	}
+	if q.MaxDuration > 0 {
+		tx = tx.Where("duration <= ?", q.MaxDuration)
+	}
+	if q.Genre != "" {
+		tx = tx.Where("genre = ? COLLATE NOCASE", q.Genre)
+	}
+	if q.Search != "" {
+		pattern := "%" + likeEscaper.Replace(q.Search) + "%"
+		tx = tx.Where(`(notes LIKE ? ESCAPE '\' OR genre LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	names := make([]string, 0, len(q.Tags))
+	seen := make(map[string]bool, len(q.Tags))
+	for _, name := range q.Tags {
+		if name = NormalizeTag(name); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 { // blank tags filter nothing rather than everything
+		tx = tx.Where(`id IN (SELECT recording_tags.recording_id FROM recording_tags
+			JOIN tags ON tags.id = recording_tags.tag_id WHERE tags.name IN ?
+			GROUP BY recording_tags.recording_id HAVING COUNT(*) = ?)`, names, len(names))
+	}
+	if q.Favorite {
+		tx = tx.Where("favorite = ?", true)
+	}
+	if q.MinRating > 0 {
+		tx = tx.Where("rating >= ?", q.MinRating)
+	}
+	if q.MinLoudness != nil {
+		tx = tx.Where("loudness >= ?", *q.MinLoudness)
+	}
+	if q.MaxLoudness != nil {
+		tx = tx.Where("loudness <= ?", *q.MaxLoudness)
+	}
+	if q.MinTruePeak != nil {
+		tx = tx.Where("true_peak >= ?", *q.MinTruePeak)
+	}
+	if q.MaxTruePeak != nil {
+		tx = tx.Where("true_peak <= ?", *q.MaxTruePeak)
+	}
+
+	tx = tx.Session(&gorm.Session{}) // reusable for both the count and the page
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	sort, desc := q.Sort, q.Desc
+	if sort == "" {
+		sort, desc = "startTime", true
+		if q.Session != 0 {
+			sort, desc = "part", false
+		}
+	}
+	column, ok := recordingSortColumns[sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort key %q", sort)
+	}
+	dir := " ASC"
+	if desc {
+		dir = " DESC"
+	}
+	if nullableSortKeys[sort] {
+		tx = tx.Order(column + " IS NULL")
+	}
+	tx = tx.Order(column + dir).Order("id" + dir)
+
+	limit := q.Limit
+	if limit <= 0 || limit > maxQueryLimit {
+		limit = maxQueryLimit
+	}
+	tx = tx.Limit(limit)
+	if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+
+	var recordings []common.Recording
+	err := tx.Preload("Tags").Find(&recordings).Error
+	return recordings, total, err
+}
+
 // GetRecordingByID retrieves a single recording by its ID.
 func GetRecordingByID(id uint) (*common.Recording, error) {
 	var rec common.Recording
-	result := dbConn.First(&rec, id)
+	result := dbConn.Preload("Tags").First(&rec, id)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &rec, nil
 }
+
+// GetUnfinishedRecordings retrieves recordings that were never finalized,
+// typically because the process stopped while they were being written.
+func GetUnfinishedRecordings() ([]common.Recording, error) {
+	var recordings []common.Recording
+	result := dbConn.Preload("Tags").Where("end_time IS NULL OR end_time <= ?", time.Time{}).Find(&recordings)
+	return recordings, result.Error
+}
+
+// CreateMarker inserts m and fills in its ID. It fails with ErrNotFound if
+// the recording does not exist.
+func CreateMarker(m *common.Marker) error {
+	if dbConn == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").First(&common.Recording{}, m.RecordingID).Error; err != nil {
+			return err
+		}
+		return tx.Create(m).Error
+	})
+}
+
+// GetMarkers retrieves the markers of a recording in file order.
+func GetMarkers(recordingID uint) ([]common.Marker, error) {
+	var markers []common.Marker
+	result := dbConn.Where("recording_id = ?", recordingID).Order("`offset`").Order("id").Find(&markers)
+	return markers, result.Error
+}
+
+// GetMarker retrieves a single marker of a recording.
+func GetMarker(recordingID, id uint) (*common.Marker, error) {
+	var m common.Marker
+	result := dbConn.Where("recording_id = ?", recordingID).First(&m, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &m, nil
+}
+
+// SaveMarker stores the editable fields of m.
+func SaveMarker(m *common.Marker) error {
+	return dbConn.Model(m).Select("Offset", "End", "Label", "Color").Updates(m).Error
+}
+
+// DeleteMarker removes a marker from a recording.
+func DeleteMarker(recordingID, id uint) error {
+	result := dbConn.Where("recording_id = ?", recordingID).Delete(&common.Marker{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateExportJob inserts job and fills in its ID. It fails with ErrNotFound
+// if the recording does not exist.
+func CreateExportJob(job *common.ExportJob) error {
+	if dbConn == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return dbConn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").First(&common.Recording{}, job.RecordingID).Error; err != nil {
+			return err
+		}
+		return tx.Create(job).Error
+	})
+}
+
+// GetExportJob retrieves a single export job.
+func GetExportJob(id uint) (*common.ExportJob, error) {
+	var job common.ExportJob
+	if err := dbConn.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetExportJobs retrieves the export jobs of a recording, or of every
+// recording if recordingID is 0, newest first.
+func GetExportJobs(recordingID uint) ([]common.ExportJob, error) {
+	var jobs []common.ExportJob
+	tx := dbConn.Order("id DESC")
+	if recordingID != 0 {
+		tx = tx.Where("recording_id = ?", recordingID)
+	}
+	return jobs, tx.Find(&jobs).Error
+}
+
+// NextExportJob retrieves the oldest job that is queued or was left running
+// when the process stopped. It fails with ErrNotFound if there is none.
+func NextExportJob() (*common.ExportJob, error) {
+	var job common.ExportJob
+	// Find rather than First: an empty queue is the normal case, not an error to log.
+	result := dbConn.Where("status IN ?", []common.JobStatus{common.JobQueued, common.JobRunning}).Order("id").Limit(1).Find(&job)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+// SaveExportJob stores the progress and outcome of job.
+func SaveExportJob(job *common.ExportJob) error {
+	return dbConn.Model(job).Select("Status", "Progress", "Error", "Filename", "FileSize", "FinishedAt").Updates(job).Error
+}
+
+// DeleteExportJob removes an export job.
+func DeleteExportJob(id uint) error {
+	result := dbConn.Delete(&common.ExportJob{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"nixon/internal/common"
+	"nixon/internal/slogger"
+)
+
+func TestMain(m *testing.M) {
+	slogger.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+// setup opens a new database for the test.
+func setup(t *testing.T) {
+	t.Helper()
+	if err := Init(filepath.Join(t.TempDir(), "nixon.db")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ptr(v float64) *float64 { return &v }
+
+// addLibrary stores four recordings an hour apart, the last two being later
+// parts of the second, and returns their start time.
+func addLibrary(t *testing.T) time.Time {
+	t.Helper()
+	t0 := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	recordings := []common.Recording{
+		{Filename: "a.wav", StartTime: t0, Duration: 10 * time.Second, FileSize: 100, Genre: "Rock", Notes: "first take",
+			Favorite: true, Rating: 5, Part: 1, Loudness: ptr(-20), TruePeak: ptr(-1)},
+		{Filename: "b.wav", StartTime: t0.Add(time.Hour), Duration: time.Minute, FileSize: 300, Genre: "rock", Notes: "100% effort",
+			Rating: 3, Part: 1, Loudness: ptr(-30), TruePeak: ptr(-6)},
+		{Filename: "c.wav", StartTime: t0.Add(2 * time.Hour), Duration: 2 * time.Minute, FileSize: 200, Genre: "Jazz", Notes: "under_score",
+			SessionID: 2, Part: 2},
+		{Filename: "d.wav", StartTime: t0.Add(3 * time.Hour), Duration: 30 * time.Second, FileSize: 50,
+			SessionID: 2, Part: 3, Rating: 1, Loudness: ptr(-10), TruePeak: ptr(0)},
+	}
+	for i := range recordings {
+		if err := CreateRecording(&recordings[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := AddTags([]uint{1, 2}, []string{"drums"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddTags([]uint{2}, []string{"live"}); err != nil {
+		t.Fatal(err)
+	}
+	return t0
+}
+
+func ids(recordings []common.Recording) []uint {
+	out := make([]uint, len(recordings))
+	for i, rec := range recordings {
+		out[i] = rec.ID
+	}
+	return out
+}
+
+func TestQueryRecordings(t *testing.T) {
+	setup(t)
+	t0 := addLibrary(t)
+
+	tests := []struct {
+		name  string
+		q     common.RecordingQuery
+		want  []uint
+		total int64 // 0 means len(want)
+	}{
+		{"everything, newest first", common.RecordingQuery{}, []uint{4, 3, 2, 1}, 0},
+		{"session in part order", common.RecordingQuery{Session: 2}, []uint{2, 3, 4}, 0},
+		{"from", common.RecordingQuery{From: t0.Add(time.Hour)}, []uint{4, 3, 2}, 0},
+		{"to", common.RecordingQuery{To: t0.Add(time.Hour)}, []uint{1}, 0},
+		{"min duration", common.RecordingQuery{MinDuration: 30 * time.Second}, []uint{4, 3, 2}, 0},
+		{"max duration", common.RecordingQuery{MaxDuration: 30 * time.Second}, []uint{4, 1}, 0},
+		{"genre ignores case", common.RecordingQuery{Genre: "ROCK"}, []uint{2, 1}, 0},
+		{"search notes", common.RecordingQuery{Search: "take"}, []uint{1}, 0},
+		{"search genre", common.RecordingQuery{Search: "jazz"}, []uint{3}, 0},
+		{"search escapes %", common.RecordingQuery{Search: "%"}, []uint{2}, 0},
+		{"search escapes _", common.RecordingQuery{Search: "_"}, []uint{3}, 0},
+		{"tag", common.RecordingQuery{Tags: []string{"Drums"}}, []uint{2, 1}, 0},
+		{"all tags must match", common.RecordingQuery{Tags: []string{"drums", "live", "drums"}}, []uint{2}, 0},
+		{"unknown tag", common.RecordingQuery{Tags: []string{"vocals"}}, []uint{}, 0},
+		{"empty tag", common.RecordingQuery{Tags: []string{""}}, []uint{4, 3, 2, 1}, 0},
+		{"blank tags", common.RecordingQuery{Tags: []string{" ", "\t"}}, []uint{4, 3, 2, 1}, 0},
+		{"blank tag beside a tag", common.RecordingQuery{Tags: []string{"live", " "}}, []uint{2}, 0},
+		{"favorite", common.RecordingQuery{Favorite: true}, []uint{1}, 0},
+		{"min rating", common.RecordingQuery{MinRating: 3}, []uint{2, 1}, 0},
+		{"min loudness", common.RecordingQuery{MinLoudness: ptr(-25)}, []uint{4, 1}, 0},
+		{"max loudness skips unanalyzed", common.RecordingQuery{MaxLoudness: ptr(-15)}, []uint{2, 1}, 0},
+		{"min true peak", common.RecordingQuery{MinTruePeak: ptr(-2)}, []uint{4, 1}, 0},
+		{"max true peak", common.RecordingQuery{MaxTruePeak: ptr(-2)}, []uint{2}, 0},
+		{"filters combine", common.RecordingQuery{Genre: "rock", MinRating: 4}, []uint{1}, 0},
+		{"sort ascending", common.RecordingQuery{Sort: "fileSize"}, []uint{4, 1, 3, 2}, 0},
+		{"sort descending", common.RecordingQuery{Sort: "fileSize", Desc: true}, []uint{2, 3, 1, 4}, 0},
+		{"unanalyzed last ascending", common.RecordingQuery{Sort: "loudness"}, []uint{2, 1, 4, 3}, 0},
+		{"unanalyzed last descending", common.RecordingQuery{Sort: "loudness", Desc: true}, []uint{4, 1, 2, 3}, 0},
+		{"limit", common.RecordingQuery{Sort: "id", Limit: 2}, []uint{1, 2}, 4},
+		{"offset", common.RecordingQuery{Sort: "id", Offset: 3}, []uint{4}, 4},
+		{"limit and offset", common.RecordingQuery{Sort: "id", Limit: 2, Offset: 1}, []uint{2, 3}, 4},
+		{"offset past the end", common.RecordingQuery{Offset: 10}, []uint{}, 4},
+		{"page of a filter", common.RecordingQuery{Tags: []string{"drums"}, Sort: "id", Limit: 1}, []uint{1}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := QueryRecordings(tt.q)
+			if err != nil {
+				t.Fatalf("QueryRecordings: %v", err)
+			}
+			if !slices.Equal(ids(got), tt.want) {
+				t.Fatalf("got recordings %v, want %v", ids(got), tt.want)
+			}
+			want := tt.total
+			if want == 0 {
+				want = int64(len(tt.want))
+			}
+			if total != want {
+				t.Fatalf("total = %d, want %d", total, want)
+			}
+		})
+	}
+}
+
+func TestQueryRecordingsRejectsUnknownSort(t *testing.T) {
+	setup(t)
+	addLibrary(t)
+	for _, sort := range []string{"notes", "start_time", "id; DROP TABLE recordings"} {
+		if _, _, err := QueryRecordings(common.RecordingQuery{Sort: sort}); err == nil {
+			t.Errorf("sorting by %q succeeded, want an error", sort)
+		}
+	}
+}
+
+func TestQueryRecordingsCapsPageSize(t *testing.T) {
+	setup(t)
+	recordings := make([]common.Recording, maxQueryLimit+5)
+	for i := range recordings {
+		recordings[i] = common.Recording{Filename: fmt.Sprintf("take%d.wav", i), StartTime: time.Now(), Part: 1}
+	}
+	if err := dbConn.CreateInBatches(recordings, 200).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int{0, maxQueryLimit + 1} {
+		got, total, err := QueryRecordings(common.RecordingQuery{Limit: limit})
+		if err != nil {
+			t.Fatalf("QueryRecordings: %v", err)
+		}
+		if len(got) != maxQueryLimit || total != int64(len(recordings)) {
+			t.Fatalf("limit %d returned %d of %d recordings, want %d of %d", limit, len(got), total, maxQueryLimit, len(recordings))
+		}
+	}
+}
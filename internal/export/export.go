@@ -0,0 +1,79 @@
+// Package export encodes recordings into compressed deliverable formats.
+// Encoders are looked up by format name, so new formats can be added by
+// registering an Encoder.
+package export
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Format describes the audio handed to an encoder.
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int   // of the source, for lossless encoders
+	Frames        int64 // total length
+}
+
+// Source supplies interleaved float samples in [-1, 1]. It returns io.EOF
+// once all frames have been read.
+type Source interface {
+	ReadFrames(buf []float32) (int, error)
+}
+
+// Options tune an encoder. Zero values select the encoder's defaults.
+type Options struct {
+	Bitrate int // kbit/s, lossy encoders only
+	// Metadata holds Vorbis comment style "NAME=value" strings, mapped to
+	// the output format's tags where it has them.
+	Metadata []string
+}
+
+// Encoder converts decoded audio into a file of one format.
+type Encoder interface {
+	// Extension is the file extension of the output, including the dot.
+	Extension() string
+	ContentType() string
+	// Encode reads src to the end and writes the encoded file at path,
+	// which must not exist yet. It stops early if ctx is cancelled.
+	Encode(ctx context.Context, path string, src Source, f Format, opts Options) error
+}
+
+var (
+	encoders   = map[string]Encoder{}
+	encodersMu sync.RWMutex
+)
+
+// Register makes an encoder available under a format name, replacing any
+// earlier one with the same name.
+func Register(name string, e Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[name] = e
+}
+
+// Lookup returns the encoder registered for a format name.
+func Lookup(name string) (Encoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	e, ok := encoders[name]
+	return e, ok
+}
+
+// Formats returns the names of the registered formats in sorted order.
+func Formats() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func init() {
+	Register("flac", flacEncoder{})
+}
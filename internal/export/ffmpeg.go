@@ -0,0 +1,108 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffmpegEncoder pipes raw float PCM through an ffmpeg binary.
+type ffmpegEncoder struct {
+	bin         string
+	codec       string
+	ext         string
+	contentType string
+	bitrate     int // default, in kbit/s
+}
+
+// RegisterFFmpeg registers the "opus" and "mp3" formats, encoded by the
+// ffmpeg binary at bin, which may be a name looked up in PATH. It fails
+// without registering anything if the binary cannot be found.
+func RegisterFFmpeg(bin string) error {
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return err
+	}
+	Register("opus", ffmpegEncoder{bin: path, codec: "libopus", ext: ".opus", contentType: "audio/ogg", bitrate: 96})
+	Register("mp3", ffmpegEncoder{bin: path, codec: "libmp3lame", ext: ".mp3", contentType: "audio/mpeg", bitrate: 192})
+	return nil
+}
+
+func (e ffmpegEncoder) Extension() string   { return e.ext }
+func (e ffmpegEncoder) ContentType() string { return e.contentType }
+
+func (e ffmpegEncoder) Encode(ctx context.Context, path string, src Source, f Format, opts Options) error {
+	bitrate := e.bitrate
+	if opts.Bitrate > 0 {
+		bitrate = opts.Bitrate
+	}
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-f", "f32le", "-ar", strconv.Itoa(f.SampleRate), "-ac", strconv.Itoa(f.Channels), "-i", "pipe:0",
+		"-c:a", e.codec, "-b:a", strconv.Itoa(bitrate) + "k",
+	}
+	for _, m := range opts.Metadata {
+		if name, value, ok := strings.Cut(m, "="); ok {
+			args = append(args, "-metadata", strings.ToLower(name)+"="+value)
+		}
+	}
+	args = append(args, "-n", path) // never overwrite
+
+	cmd := exec.CommandContext(ctx, e.bin, args...)
+	cmd.WaitDelay = 5 * time.Second // don't hang on output pipes held open after a kill
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	writeErr := pipeFloats(stdin, src, f.Channels)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		os.Remove(path)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if writeErr != nil {
+		os.Remove(path)
+		return writeErr
+	}
+	return nil
+}
+
+// pipeFloats writes every frame of src to w as little-endian float32.
+func pipeFloats(w io.Writer, src Source, channels int) error {
+	buf := make([]float32, 4096*channels)
+	raw := make([]byte, len(buf)*4)
+	for {
+		n, err := src.ReadFrames(buf)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		samples := buf[:n*channels]
+		for i, s := range samples {
+			binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(s))
+		}
+		if _, err := w.Write(raw[:len(samples)*4]); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"nixon/internal/flac"
+)
+
+// flacEncoderLevel trades encoding time for size; exports are made once and
+// downloaded many times, so it favours size.
+const flacEncoderLevel = 8
+
+// flacEncoder writes FLAC with the pure-Go encoder. Sources deeper than 16
+// bits are kept at 24.
+type flacEncoder struct{}
+
+func (flacEncoder) Extension() string   { return ".flac" }
+func (flacEncoder) ContentType() string { return "audio/flac" }
+
+func (flacEncoder) Encode(ctx context.Context, path string, src Source, f Format, opts Options) (err error) {
+	bits := 24
+	if f.BitsPerSample > 0 && f.BitsPerSample <= 16 {
+		bits = 16
+	}
+	w, err := flac.Create(path, f.SampleRate, f.Channels, bits, flacEncoderLevel)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	w.SetComments(opts.Metadata)
+
+	buf := make([]float32, 4096*f.Channels)
+	for {
+		if err := ctx.Err(); err != nil {
+			w.Close()
+			return err
+		}
+		n, err := src.ReadFrames(buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.WriteFrames(buf[:n*f.Channels]); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
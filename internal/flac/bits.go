@@ -0,0 +1,89 @@
+package flac
+
+// bitWriter packs values MSB-first into a byte slice.
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint // bits held in acc, always < 8 between calls
+}
+
+func (b *bitWriter) reset() {
+	b.buf, b.acc, b.n = b.buf[:0], 0, 0
+}
+
+// writeBits appends the low n bits of v. n must not exceed 56.
+func (b *bitWriter) writeBits(v uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	b.acc = b.acc<<n | v&(1<<n-1)
+	b.n += n
+	for b.n >= 8 {
+		b.n -= 8
+		b.buf = append(b.buf, byte(b.acc>>b.n))
+	}
+}
+
+// writeSigned appends v as an n-bit two's complement number.
+func (b *bitWriter) writeSigned(v int64, n uint) {
+	b.writeBits(uint64(v), n)
+}
+
+// writeRice appends u Rice-coded with parameter k: the quotient in unary,
+// as zeros terminated by a one, then the k low bits.
+func (b *bitWriter) writeRice(u uint64, k uint) {
+	for q := u >> k; ; q -= 32 {
+		if q < 32 {
+			b.writeBits(0, uint(q))
+			break
+		}
+		b.writeBits(0, 32)
+	}
+	b.writeBits(1<<k|u&(1<<k-1), k+1)
+}
+
+// align pads with zero bits to the next byte boundary.
+func (b *bitWriter) align() {
+	if b.n > 0 {
+		b.writeBits(0, 8-b.n)
+	}
+}
+
+// crc8Table and crc16Table implement the frame header (x^8+x^2+x+1) and
+// frame (x^16+x^15+x^2+1) checksums.
+var crc8Table, crc16Table = func() (t8 [256]uint8, t16 [256]uint16) {
+	for i := range 256 {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for range 8 {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		t8[i], t16[i] = c8, c16
+	}
+	return
+}()
+
+func crc8(b []byte) uint8 {
+	var c uint8
+	for _, v := range b {
+		c = crc8Table[c^v]
+	}
+	return c
+}
+
+func crc16(b []byte) uint16 {
+	var c uint16
+	for _, v := range b {
+		c = c<<8 ^ crc16Table[byte(c>>8)^v]
+	}
+	return c
+}
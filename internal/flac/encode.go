@@ -0,0 +1,600 @@
+package flac
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// params are the encoder settings of a compression level.
+type params struct {
+	blockSize         int
+	stereo            bool // try left/side, side/right and mid/side coding
+	maxLPCOrder       int  // 0 uses the fixed predictors only
+	exhaustive        bool // try every LPC order rather than the estimated best
+	maxPartitionOrder int
+}
+
+// levels follow the trade-offs of the reference encoder's -0 to -8.
+var levels = [...]params{
+	{blockSize: 1152, maxPartitionOrder: 3},
+	{blockSize: 1152, stereo: true, maxPartitionOrder: 3},
+	{blockSize: 1152, stereo: true, maxPartitionOrder: 4},
+	{blockSize: 4096, maxLPCOrder: 6, maxPartitionOrder: 4},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 8, maxPartitionOrder: 4},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 8, maxPartitionOrder: 5},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 8, maxPartitionOrder: 6},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 12, maxPartitionOrder: 6},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 12, exhaustive: true, maxPartitionOrder: 6},
+}
+
+const (
+	maxFixedOrder = 4
+
+	channelLeftSide  = 8
+	channelSideRight = 9
+	channelMidSide   = 10
+
+	subframeConstant = 0
+	subframeVerbatim = 1
+	subframeFixed    = 8
+	subframeLPC      = 32
+)
+
+// encodeFrame encodes one block of per-channel samples as a complete frame.
+func (w *Writer) encodeFrame(block [][]int32) []byte {
+	n := len(block[0])
+	chans := make([][]int64, len(block))
+	depths := make([]uint, len(block))
+	for c, src := range block {
+		chans[c] = make([]int64, n)
+		for i, v := range src {
+			chans[c][i] = int64(v)
+		}
+		depths[c] = uint(w.bitsPerSample)
+	}
+	assignment := len(block) - 1
+	if len(block) == 2 && w.params.stereo {
+		assignment, chans, depths = decorrelate(chans, uint(w.bitsPerSample))
+	}
+
+	bw := &w.bw
+	bw.reset()
+	w.writeFrameHeader(bw, n, assignment)
+	for c, x := range chans {
+		w.encodeSubframe(bw, x, depths[c])
+	}
+	bw.align()
+	crc := crc16(bw.buf)
+	return binary.BigEndian.AppendUint16(bw.buf, crc)
+}
+
+// writeFrameHeader writes the sync code, block and format description, frame
+// number and header CRC.
+func (w *Writer) writeFrameHeader(bw *bitWriter, n, assignment int) {
+	bsCode, bsExtra, bsBits := blockSizeCode(n)
+	srCode, srExtra, srBits := sampleRateCode(w.sampleRate)
+
+	bw.writeBits(0xFFF8, 16) // sync code, fixed block size
+	bw.writeBits(uint64(bsCode), 4)
+	bw.writeBits(uint64(srCode), 4)
+	bw.writeBits(uint64(assignment), 4)
+	bw.writeBits(uint64(sampleSizeCode(w.bitsPerSample)), 3)
+	bw.writeBits(0, 1)
+	bw.buf = appendUTF8(bw.buf, w.frameNum)
+	bw.writeBits(uint64(bsExtra), bsBits)
+	bw.writeBits(uint64(srExtra), srBits)
+	bw.writeBits(uint64(crc8(bw.buf)), 8)
+}
+
+func blockSizeCode(n int) (code, extra int, extraBits uint) {
+	switch n {
+	case 192:
+		return 1, 0, 0
+	case 576, 1152, 2304, 4608:
+		return 2 + bits.TrailingZeros(uint(n/576)), 0, 0
+	case 256, 512, 1024, 2048, 4096, 8192, 16384, 32768:
+		return 8 + bits.TrailingZeros(uint(n/256)), 0, 0
+	}
+	if n <= 256 {
+		return 6, n - 1, 8
+	}
+	return 7, n - 1, 16
+}
+
+var sampleRateCodes = map[int]int{
+	88200: 1, 176400: 2, 192000: 3, 8000: 4, 16000: 5, 22050: 6,
+	24000: 7, 32000: 8, 44100: 9, 48000: 10, 96000: 11,
+}
+
+func sampleRateCode(rate int) (code, extra int, extraBits uint) {
+	if c, ok := sampleRateCodes[rate]; ok {
+		return c, 0, 0
+	}
+	switch {
+	case rate%1000 == 0 && rate/1000 <= 255:
+		return 12, rate / 1000, 8
+	case rate <= 65535:
+		return 13, rate, 16
+	case rate%10 == 0 && rate/10 <= 65535:
+		return 14, rate / 10, 16
+	}
+	return 0, 0, 0 // taken from STREAMINFO
+}
+
+func sampleSizeCode(bps int) int {
+	switch bps {
+	case 8:
+		return 1
+	case 12:
+		return 2
+	case 16:
+		return 4
+	case 20:
+		return 5
+	case 24:
+		return 6
+	}
+	return 0
+}
+
+// appendUTF8 appends v in the extended UTF-8 coding used for frame numbers.
+func appendUTF8(b []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(b, byte(v))
+	}
+	n := 2
+	for v >= 1<<(5*n+1) && n < 7 {
+		n++
+	}
+	b = append(b, byte(uint(0xFF00)>>n)|byte(v>>(6*(n-1))))
+	for i := n - 2; i >= 0; i-- {
+		b = append(b, 0x80|byte(v>>(6*i))&0x3F)
+	}
+	return b
+}
+
+// decorrelate picks the cheapest of the four stereo codings by the size
+// estimated from the fixed predictors, or verbatim if that is smaller.
+func decorrelate(chans [][]int64, bps uint) (int, [][]int64, []uint) {
+	left, right := chans[0], chans[1]
+	n := len(left)
+	mid, side := make([]int64, n), make([]int64, n)
+	for i := range n {
+		side[i] = left[i] - right[i]
+		mid[i] = (left[i] + right[i]) >> 1
+	}
+
+	verbatim, verbatimSide := int64(n)*int64(bps), int64(n)*int64(bps+1)
+	l, r := min(estimateBits(left), verbatim), min(estimateBits(right), verbatim)
+	m, s := min(estimateBits(mid), verbatim), min(estimateBits(side), verbatimSide)
+	best, assignment := l+r, 1
+	if l+s < best {
+		best, assignment = l+s, channelLeftSide
+	}
+	if s+r < best {
+		best, assignment = s+r, channelSideRight
+	}
+	if m+s < best {
+		assignment = channelMidSide
+	}
+
+	switch assignment {
+	case channelLeftSide:
+		return assignment, [][]int64{left, side}, []uint{bps, bps + 1}
+	case channelSideRight:
+		return assignment, [][]int64{side, right}, []uint{bps + 1, bps}
+	case channelMidSide:
+		return assignment, [][]int64{mid, side}, []uint{bps, bps + 1}
+	}
+	return assignment, chans, []uint{bps, bps}
+}
+
+// estimateBits estimates the coded size of x with its best fixed predictor.
+func estimateBits(x []int64) int64 {
+	_, bits := bestFixedOrder(x)
+	return bits
+}
+
+// bestFixedOrder returns the fixed predictor order with the smallest summed
+// residual magnitude, and an estimate of its coded size.
+func bestFixedOrder(x []int64) (int, int64) {
+	var sums [maxFixedOrder + 1]uint64
+	for i := maxFixedOrder; i < len(x); i++ {
+		e0 := x[i]
+		e1 := e0 - x[i-1]
+		e2 := e1 - (x[i-1] - x[i-2])
+		e3 := e2 - (x[i-1] - 2*x[i-2] + x[i-3])
+		e4 := e3 - (x[i-1] - 3*x[i-2] + 3*x[i-3] - x[i-4])
+		sums[0] += abs(e0)
+		sums[1] += abs(e1)
+		sums[2] += abs(e2)
+		sums[3] += abs(e3)
+		sums[4] += abs(e4)
+	}
+	order := 0
+	for o := 1; o <= maxFixedOrder; o++ {
+		if sums[o] < sums[order] {
+			order = o
+		}
+	}
+	cnt := uint64(max(len(x)-maxFixedOrder, 1))
+	k := riceParam(2*sums[order], cnt, 30) // residuals are zigzag coded
+	return order, int64(cnt*uint64(k+1) + (2*sums[order])>>k)
+}
+
+func abs(v int64) uint64 {
+	if v < 0 {
+		return uint64(-v)
+	}
+	return uint64(v)
+}
+
+// subframe is a candidate encoding of one channel.
+type subframe struct {
+	kind      int
+	order     int
+	coefs     []int32
+	precision uint
+	shift     int
+	residual  []uint64 // zigzag-coded; the first order entries are unused
+	rice      rice
+	bits      int64
+}
+
+// encodeSubframe writes the smallest encoding of x found at the current level.
+func (w *Writer) encodeSubframe(bw *bitWriter, x []int64, bps uint) {
+	n := len(x)
+	best := subframe{kind: subframeVerbatim, bits: 8 + int64(n)*int64(bps)}
+
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		best = subframe{kind: subframeConstant, bits: 8 + int64(bps)}
+	} else {
+		if order, _ := bestFixedOrder(x); order < n {
+			res := make([]uint64, n)
+			fixedResidual(x, order, res)
+			rc, rbits := bestRice(res, order, w.params.maxPartitionOrder)
+			if b := 8 + int64(order)*int64(bps) + rbits; b < best.bits {
+				best = subframe{kind: subframeFixed, order: order, residual: res, rice: rc, bits: b}
+			}
+		}
+		if w.params.maxLPCOrder > 0 {
+			if c, ok := w.bestLPC(x, bps); ok && c.bits < best.bits {
+				best = c
+			}
+		}
+	}
+
+	switch best.kind {
+	case subframeConstant:
+		bw.writeBits(subframeConstant<<1, 8)
+		bw.writeSigned(x[0], bps)
+	case subframeVerbatim:
+		bw.writeBits(subframeVerbatim<<1, 8)
+		for _, v := range x {
+			bw.writeSigned(v, bps)
+		}
+	case subframeFixed:
+		bw.writeBits(uint64(subframeFixed|best.order)<<1, 8)
+		for _, v := range x[:best.order] {
+			bw.writeSigned(v, bps)
+		}
+		writeResidual(bw, best.residual, best.order, best.rice)
+	case subframeLPC:
+		bw.writeBits(uint64(subframeLPC|(best.order-1))<<1, 8)
+		for _, v := range x[:best.order] {
+			bw.writeSigned(v, bps)
+		}
+		bw.writeBits(uint64(best.precision-1), 4)
+		bw.writeSigned(int64(best.shift), 5)
+		for _, c := range best.coefs {
+			bw.writeSigned(int64(c), best.precision)
+		}
+		writeResidual(bw, best.residual, best.order, best.rice)
+	}
+}
+
+// fixedResidual computes the zigzag-coded residual of a fixed predictor.
+func fixedResidual(x []int64, order int, out []uint64) {
+	for i := order; i < len(x); i++ {
+		var e int64
+		switch order {
+		case 0:
+			e = x[i]
+		case 1:
+			e = x[i] - x[i-1]
+		case 2:
+			e = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			e = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			e = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+		out[i] = zigzag(e)
+	}
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// bestLPC finds the best linear predictor for x: coefficients from the
+// windowed autocorrelation by Levinson-Durbin recursion, quantized to a
+// fixed precision.
+func (w *Writer) bestLPC(x []int64, bps uint) (subframe, bool) {
+	n := len(x)
+	maxOrder := min(w.params.maxLPCOrder, n-1)
+	if maxOrder < 1 {
+		return subframe{}, false
+	}
+
+	window := w.window(n)
+	xw := make([]float64, n)
+	for i, v := range x {
+		xw[i] = float64(v) * window[i]
+	}
+	autoc := make([]float64, maxOrder+1)
+	for lag := range autoc {
+		var sum float64
+		for i := lag; i < n; i++ {
+			sum += xw[i] * xw[i-lag]
+		}
+		autoc[lag] = sum
+	}
+	if autoc[0] == 0 {
+		return subframe{}, false
+	}
+	coefs, errs := levinson(autoc, maxOrder)
+
+	precision := uint(12)
+	if bps > 16 {
+		precision = 14
+	}
+	orders := []int{}
+	if w.params.exhaustive {
+		for o := 1; o <= maxOrder; o++ {
+			orders = append(orders, o)
+		}
+	} else {
+		// Estimate each order's size from its prediction error, assuming
+		// roughly Laplacian residuals.
+		best, bestBits := 0, math.Inf(1)
+		for o := 1; o <= maxOrder; o++ {
+			perSample := 0.5*math.Log2(max(errs[o-1]/float64(n), 1e-10)) + 1
+			est := float64(n-o)*max(perSample, 1) + float64(o)*float64(bps+precision)
+			if est < bestBits {
+				best, bestBits = o, est
+			}
+		}
+		orders = append(orders, best)
+	}
+
+	var best subframe
+	found := false
+	for _, order := range orders {
+		qlp, shift, ok := quantizeCoefs(coefs[order-1], precision)
+		if !ok {
+			continue
+		}
+		res := make([]uint64, n)
+		if !lpcResidual(x, qlp, shift, res) {
+			continue
+		}
+		rc, rbits := bestRice(res, order, w.params.maxPartitionOrder)
+		b := 8 + int64(order)*int64(bps) + 4 + 5 + int64(order)*int64(precision) + rbits
+		if !found || b < best.bits {
+			best = subframe{kind: subframeLPC, order: order, coefs: qlp, precision: precision, shift: shift, residual: res, rice: rc, bits: b}
+			found = true
+		}
+	}
+	return best, found
+}
+
+// window returns a Tukey(0.5) window of length n, cached per length.
+func (w *Writer) window(n int) []float64 {
+	if len(w.win) == n {
+		return w.win
+	}
+	w.win = make([]float64, n)
+	taper := n / 4
+	for i := range n {
+		w.win[i] = 1
+		if i < taper {
+			w.win[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		} else if i >= n-taper {
+			w.win[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(n-1-i)/float64(taper))
+		}
+	}
+	return w.win
+}
+
+// levinson solves for the predictor coefficients of every order up to
+// maxOrder, returning them with the remaining prediction error of each.
+func levinson(autoc []float64, maxOrder int) ([][]float64, []float64) {
+	lpc := make([]float64, maxOrder)
+	coefs := make([][]float64, maxOrder)
+	errs := make([]float64, maxOrder)
+	e := autoc[0]
+	for i := range maxOrder {
+		r := -autoc[i+1]
+		for j := range i {
+			r -= lpc[j] * autoc[i-j]
+		}
+		if e > 0 {
+			r /= e
+		} else {
+			r = 0
+		}
+		lpc[i] = r
+		j := 0
+		for ; j < i>>1; j++ {
+			tmp := lpc[j]
+			lpc[j] += r * lpc[i-1-j]
+			lpc[i-1-j] += r * tmp
+		}
+		if i&1 == 1 {
+			lpc[j] += lpc[j] * r
+		}
+		e *= 1 - r*r
+		coefs[i] = make([]float64, i+1)
+		for k := range i + 1 {
+			coefs[i][k] = -lpc[k]
+		}
+		errs[i] = e
+	}
+	return coefs, errs
+}
+
+// quantizeCoefs converts coefficients to precision-bit integers with a
+// common shift, carrying the rounding error forward.
+func quantizeCoefs(lp []float64, precision uint) ([]int32, int, bool) {
+	qmax := int64(1)<<(precision-1) - 1
+	qmin := -qmax - 1
+
+	var cmax float64
+	for _, c := range lp {
+		cmax = max(cmax, math.Abs(c))
+	}
+	if cmax <= 0 {
+		return nil, 0, false
+	}
+	_, log2cmax := math.Frexp(cmax)
+	shift := int(precision) - log2cmax - 1
+	if shift > 15 {
+		shift = 15
+	} else if shift < 0 {
+		return nil, 0, false
+	}
+
+	q := make([]int32, len(lp))
+	var e float64
+	for i, c := range lp {
+		e += c * float64(int64(1)<<shift)
+		v := int64(math.Round(e))
+		v = min(max(v, qmin), qmax)
+		e -= float64(v)
+		q[i] = int32(v)
+	}
+	return q, shift, true
+}
+
+// lpcResidual computes the zigzag-coded residual of a quantized predictor.
+// It reports false if a residual would not fit the 32 bits decoders allow.
+func lpcResidual(x []int64, qlp []int32, shift int, out []uint64) bool {
+	order := len(qlp)
+	for i := order; i < len(x); i++ {
+		var sum int64
+		for j, c := range qlp {
+			sum += int64(c) * x[i-1-j]
+		}
+		e := x[i] - sum>>shift
+		if e > math.MaxInt32 || e <= math.MinInt32 {
+			return false
+		}
+		out[i] = zigzag(e)
+	}
+	return true
+}
+
+// rice describes a partitioned Rice coding of a residual.
+type rice struct {
+	method         int // 0: 4-bit parameters, 1: 5-bit parameters
+	partitionOrder int
+	params         []uint
+}
+
+// bestRice chooses the partition order and per-partition parameters that
+// minimize the estimated size of res, whose first order entries are warm-up
+// samples and not coded.
+func bestRice(res []uint64, order, maxPartitionOrder int) (rice, int64) {
+	n := len(res)
+	top := maxPartitionOrder
+	for top > 0 && (n%(1<<top) != 0 || n>>top <= order) {
+		top--
+	}
+
+	// Partition sums at the finest order, then merged pairwise.
+	sums := make([][]uint64, top+1)
+	sums[top] = make([]uint64, 1<<top)
+	size := n >> top
+	for p := range sums[top] {
+		start := max(p*size, order)
+		for _, u := range res[start : (p+1)*size] {
+			sums[top][p] += u
+		}
+	}
+	for po := top - 1; po >= 0; po-- {
+		sums[po] = make([]uint64, 1<<po)
+		for p := range sums[po] {
+			sums[po][p] = sums[po+1][2*p] + sums[po+1][2*p+1]
+		}
+	}
+
+	var best rice
+	bestBits := int64(-1)
+	for method, maxK := range []uint{14, 30} {
+		paramBits := int64(4 + method)
+		clamped := false
+		for po := 0; po <= top; po++ {
+			bits := int64(6)
+			params := make([]uint, 1<<po)
+			for p, sum := range sums[po] {
+				cnt := uint64(n >> po)
+				if p == 0 {
+					cnt -= uint64(order)
+				}
+				k := riceParam(sum, cnt, maxK)
+				if k == maxK {
+					clamped = true
+				}
+				params[p] = k
+				bits += paramBits + int64(cnt*uint64(k+1)+sum>>k)
+			}
+			if bestBits < 0 || bits < bestBits {
+				best, bestBits = rice{method: method, partitionOrder: po, params: params}, bits
+			}
+		}
+		if !clamped {
+			break
+		}
+	}
+	return best, bestBits
+}
+
+// riceParam returns the Rice parameter minimizing the estimated size of cnt
+// values summing to sum.
+func riceParam(sum, cnt uint64, maxK uint) uint {
+	if cnt == 0 {
+		return 0
+	}
+	k := uint(0)
+	for k < maxK && cnt<<k < sum {
+		k++
+	}
+	if k > 0 && cnt*uint64(k)+sum>>(k-1) <= cnt*uint64(k+1)+sum>>k {
+		k--
+	}
+	return k
+}
+
+// writeResidual writes a residual coded as described by rc.
+func writeResidual(bw *bitWriter, res []uint64, order int, rc rice) {
+	bw.writeBits(uint64(rc.method), 2)
+	bw.writeBits(uint64(rc.partitionOrder), 4)
+	paramBits := uint(4 + rc.method)
+	size := len(res) >> rc.partitionOrder
+	for p, k := range rc.params {
+		bw.writeBits(uint64(k), paramBits)
+		start := max(p*size, order)
+		for _, u := range res[start : (p+1)*size] {
+			bw.writeRice(u, k)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Package flac reads and writes FLAC files as interleaved float samples.
+package flac
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"os"
+)
+
+const (
+	streamInfoOffset = 8 // after the "fLaC" marker and the STREAMINFO block header
+	streamInfoSize   = 34
+	// metadataReserve is the space kept for the Vorbis comment block so it can
+	// be rewritten in place, without moving the audio.
+	metadataReserve = 8192
+	audioOffset     = streamInfoOffset + streamInfoSize + metadataReserve
+
+	blockStreamInfo    = 0
+	blockPadding       = 1
+	blockVorbisComment = 4
+
+	// maxTotalSamples is the largest length STREAMINFO can describe.
+	maxTotalSamples = 1<<36 - 1
+
+	vendor = "Nixon"
+)
+
+// ErrMetadataTooLarge is returned when Vorbis comments do not fit the space
+// reserved for them.
+var ErrMetadataTooLarge = errors.New("flac: metadata exceeds the reserved space")
+
+// Writer streams interleaved samples to a FLAC file.
+//
+// Frames are written as soon as a block is complete, and STREAMINFO is
+// rewritten on every Sync, so a file interrupted by a crash is playable up to
+// its last complete frame. Repair can fix up the rest.
+type Writer struct {
+	f             *os.File
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	params        params
+
+	pending  [][]int32 // samples of the block being filled, per channel
+	frames   int64     // frames accepted, including pending ones
+	encoded  int64     // frames in complete FLAC frames on disk
+	frameNum uint64
+	size     int64
+	minFrame int
+	maxFrame int
+	md5      hash.Hash
+	md5buf   []byte
+	comments []string
+
+	bw  bitWriter
+	win []float64
+}
+
+// Create creates the file at path and writes its metadata blocks. Supported
+// bit depths are 16 and 24; level is the compression level, 0 to 8.
+func Create(path string, sampleRate, channels, bitsPerSample, level int) (*Writer, error) {
+	if bitsPerSample != 16 && bitsPerSample != 24 {
+		return nil, fmt.Errorf("flac: unsupported bit depth %d", bitsPerSample)
+	}
+	if sampleRate <= 0 || sampleRate >= 1<<20 || channels <= 0 || channels > 8 {
+		return nil, fmt.Errorf("flac: invalid format %d Hz / %d ch", sampleRate, channels)
+	}
+	if level < 0 || level >= len(levels) {
+		return nil, fmt.Errorf("flac: invalid compression level %d", level)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		f:             f,
+		sampleRate:    sampleRate,
+		channels:      channels,
+		bitsPerSample: bitsPerSample,
+		params:        levels[level],
+		pending:       make([][]int32, channels),
+		md5:           md5.New(),
+		size:          audioOffset,
+	}
+	header := append([]byte("fLaC"), blockHeader(blockStreamInfo, false, streamInfoSize)...)
+	header = append(header, w.streamInfo(false)...)
+	comments, err := metadataArea(nil)
+	if err == nil {
+		header = append(header, comments...)
+		_, err = f.Write(header)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return w, nil
+}
+
+// blockHeader encodes a metadata block header.
+func blockHeader(typ byte, last bool, length int) []byte {
+	h := []byte{typ, byte(length >> 16), byte(length >> 8), byte(length)}
+	if last {
+		h[0] |= 0x80
+	}
+	return h
+}
+
+// streamInfo encodes the STREAMINFO block for the frames on disk. The MD5
+// signature is only filled in once the stream is complete.
+func (w *Writer) streamInfo(final bool) []byte {
+	b := make([]byte, streamInfoSize)
+	binary.BigEndian.PutUint16(b[0:2], uint16(w.params.blockSize))
+	binary.BigEndian.PutUint16(b[2:4], uint16(w.params.blockSize))
+	putUint24(b[4:7], w.minFrame)
+	putUint24(b[7:10], w.maxFrame)
+	v := uint64(w.sampleRate)<<44 | uint64(w.channels-1)<<41 | uint64(w.bitsPerSample-1)<<36 | uint64(w.encoded)&maxTotalSamples
+	binary.BigEndian.PutUint64(b[10:18], v)
+	if final {
+		copy(b[18:34], w.md5.Sum(nil))
+	}
+	return b
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+// WriteFrames converts interleaved float samples in [-1, 1] to integers and
+// encodes every complete block. len(samples) must be a multiple of the
+// channel count.
+func (w *Writer) WriteFrames(samples []float32) error {
+	if len(samples)%w.channels != 0 {
+		return fmt.Errorf("flac: %d samples is not a multiple of %d channels", len(samples), w.channels)
+	}
+	bytesPerSample := w.bitsPerSample / 8
+	if cap(w.md5buf) < len(samples)*bytesPerSample {
+		w.md5buf = make([]byte, len(samples)*bytesPerSample)
+	}
+	md5buf := w.md5buf[:len(samples)*bytesPerSample]
+
+	for i, s := range samples {
+		v := quantize(s, w.bitsPerSample)
+		c := i % w.channels
+		w.pending[c] = append(w.pending[c], v)
+		o := i * bytesPerSample
+		for j := range bytesPerSample {
+			md5buf[o+j] = byte(v >> (8 * j))
+		}
+	}
+	w.md5.Write(md5buf)
+	w.frames += int64(len(samples) / w.channels)
+
+	for len(w.pending[0]) >= w.params.blockSize {
+		if err := w.flushBlock(w.params.blockSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quantize scales a float sample to a signed integer of the given bit depth, clipping at full scale.
+func quantize(s float32, bits int) int32 {
+	max := float32(int32(1)<<(bits-1) - 1)
+	if s > 1 {
+		s = 1
+	} else if s < -1 {
+		s = -1
+	}
+	return int32(math.Round(float64(s * max)))
+}
+
+// flushBlock encodes the first n pending frames and appends them to the file.
+func (w *Writer) flushBlock(n int) error {
+	if w.encoded+int64(n) > maxTotalSamples {
+		return fmt.Errorf("flac: stream exceeds %d samples", int64(maxTotalSamples))
+	}
+	block := make([][]int32, w.channels)
+	for c := range block {
+		block[c] = w.pending[c][:n]
+	}
+	frame := w.encodeFrame(block)
+	for c := range w.pending {
+		w.pending[c] = append(w.pending[c][:0], w.pending[c][n:]...)
+	}
+
+	written, err := w.f.Write(frame)
+	w.size += int64(written)
+	if err != nil {
+		return err
+	}
+	w.encoded += int64(n)
+	w.frameNum++
+	if w.minFrame == 0 || len(frame) < w.minFrame {
+		w.minFrame = len(frame)
+	}
+	w.maxFrame = max(w.maxFrame, len(frame))
+	return nil
+}
+
+// Sync rewrites STREAMINFO to describe the frames written so far and flushes to disk.
+func (w *Writer) Sync() error {
+	if _, err := w.f.WriteAt(w.streamInfo(false), streamInfoOffset); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// SetComments sets the Vorbis comments, as "NAME=value" strings, to be
+// written when the file is closed.
+func (w *Writer) SetComments(comments []string) {
+	w.comments = comments
+}
+
+// Close encodes the final partial block, writes the metadata and closes the file.
+func (w *Writer) Close() error {
+	var err error
+	if n := len(w.pending[0]); n > 0 {
+		err = w.flushBlock(n)
+	}
+	if err == nil && len(w.comments) > 0 {
+		var area []byte
+		if area, err = metadataArea(w.comments); err == nil {
+			_, err = w.f.WriteAt(area, streamInfoOffset+streamInfoSize)
+		}
+	}
+	if err == nil {
+		if _, err = w.f.WriteAt(w.streamInfo(true), streamInfoOffset); err == nil {
+			err = w.f.Sync()
+		}
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// MaxFrames returns the largest number of frames a FLAC stream can hold.
+func MaxFrames() int64 {
+	return maxTotalSamples
+}
+
+// Frames returns the number of sample frames written, including those not
+// yet encoded.
+func (w *Writer) Frames() int64 {
+	return w.frames
+}
+
+// EncodedFrames returns the number of sample frames in complete FLAC frames on disk.
+func (w *Writer) EncodedFrames() int64 {
+	return w.encoded
+}
+
+// Size returns the current size of the file in bytes.
+func (w *Writer) Size() int64 {
+	return w.size
+}
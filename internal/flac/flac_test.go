@@ -0,0 +1,339 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSignal returns interleaved frames that exercise every subframe type:
+// silence for constant subframes, tones that LPC predicts well, noise that
+// only verbatim or low orders suit, and clipped full scale. Channels differ
+// but are correlated, so stereo decorrelation has something to work with.
+func testSignal(frames, channels int) []float32 {
+	rng := rand.New(rand.NewSource(int64(frames*channels + 1)))
+	s := make([]float32, frames*channels)
+	for i := range frames {
+		t := float64(i) / 48000
+		for c := range channels {
+			var v float64
+			switch seg := i / 2500; seg % 4 {
+			case 0:
+				v = 0
+			case 1:
+				v = 0.5*math.Sin(2*math.Pi*440*t) + 0.1*float64(c)*math.Sin(2*math.Pi*97*t)
+			case 2:
+				v = rng.Float64()*2 - 1
+			case 3:
+				v = 1.5 * math.Sin(2*math.Pi*float64(60+c)*t)
+			}
+			s[i*channels+c] = float32(v)
+		}
+	}
+	return s
+}
+
+// writeFile encodes samples to a new file and returns its path.
+func writeFile(t *testing.T, samples []float32, channels, bits, level int, comments ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "take.flac")
+	w, err := Create(path, 48000, channels, bits, level)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// Write in uneven pieces so blocks straddle calls.
+	for len(samples) > 0 {
+		n := min(len(samples), 1000*channels)
+		if err := w.WriteFrames(samples[:n]); err != nil {
+			t.Fatalf("WriteFrames: %v", err)
+		}
+		samples = samples[n:]
+	}
+	w.SetComments(comments)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return path
+}
+
+// decode reads every frame of the file at path as integers.
+func decode(t *testing.T, path string) (Info, []int32, error) {
+	t.Helper()
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	info := r.Info()
+	scale := float32(int64(1) << (info.BitsPerSample - 1))
+	var out []int32
+	buf := make([]float32, 777*info.Channels)
+	for {
+		n, err := r.ReadFrames(buf)
+		if errors.Is(err, io.EOF) {
+			return info, out, nil
+		}
+		if err != nil {
+			return info, out, err
+		}
+		for _, v := range buf[:n*info.Channels] {
+			out = append(out, int32(v*scale))
+		}
+	}
+}
+
+// quantized returns the integers the encoder stores for samples.
+func quantized(samples []float32, bits int) []int32 {
+	out := make([]int32, len(samples))
+	for i, s := range samples {
+		out[i] = quantize(s, bits)
+	}
+	return out
+}
+
+// checkDecoded fails unless got is exactly want.
+func checkDecoded(t *testing.T, got, want []int32) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("decoded %d samples, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d = %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+// signature returns the MD5 of samples the way STREAMINFO records it.
+func signature(samples []int32, bits int) []byte {
+	h := md5.New()
+	for _, v := range samples {
+		for j := range bits / 8 {
+			h.Write([]byte{byte(v >> (8 * j))})
+		}
+	}
+	return h.Sum(nil)
+}
+
+// frameEnds decodes the frames of the file at path and returns the offset
+// just past each one.
+func frameEnds(t *testing.T, path string) []int64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, audio, err := readInfo(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bitReader{r: bufio.NewReader(io.NewSectionReader(f, audio, info.Size-audio))}
+	block := make([][]int32, info.Channels)
+	var ends []int64
+	for num := uint64(0); ; num++ {
+		if _, err := readFrame(&br, info, block, num); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("frame %d: %v", num, err)
+			}
+			return ends
+		}
+		ends = append(ends, audio+br.consumed)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const frames = 10007 // not a whole number of blocks
+	for _, bits := range []int{16, 24} {
+		for _, channels := range []int{1, 2, 4} {
+			samples := testSignal(frames, channels)
+			want := quantized(samples, bits)
+			for level := range len(levels) {
+				t.Run(fmt.Sprintf("%dbit/%dch/level%d", bits, channels, level), func(t *testing.T) {
+					path := writeFile(t, samples, channels, bits, level)
+					info, got, err := decode(t, path)
+					if err != nil {
+						t.Fatalf("decoding: %v", err)
+					}
+					if info.SampleRate != 48000 || info.Channels != channels || info.BitsPerSample != bits || info.Frames != frames {
+						t.Fatalf("Info = %+v, want 48000 Hz, %d ch, %d bit, %d frames", info, channels, bits, frames)
+					}
+					checkDecoded(t, got, want)
+
+					data, err := os.ReadFile(path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if sig := data[streamInfoOffset+18 : streamInfoOffset+34]; !bytes.Equal(sig, signature(want, bits)) {
+						t.Fatalf("MD5 signature %x, want %x", sig, signature(want, bits))
+					}
+					if st, _ := os.Stat(path); level > 0 && st.Size() >= int64(audioOffset+len(want)*bits/8) {
+						t.Fatalf("level %d file of %d bytes is not smaller than the raw audio", level, st.Size())
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCorruptFrames(t *testing.T) {
+	samples := testSignal(20000, 2)
+	path := writeFile(t, samples, 2, 16, 5)
+	ends := frameEnds(t, path) // ends[i] is just past frame i
+	if len(ends) != 5 {
+		t.Fatalf("got %d frames, want 5", len(ends))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func([]byte) []byte
+	}{
+		{"flipped bit in frame data", func(b []byte) []byte {
+			b[(ends[1]+ends[2])/2] ^= 0x10
+			return b
+		}},
+		{"flipped bit in frame header", func(b []byte) []byte {
+			b[ends[1]+2] ^= 0x01
+			return b
+		}},
+		{"missing frame", func(b []byte) []byte {
+			return append(b[:ends[1]:ends[1]], b[ends[2]:]...)
+		}},
+		{"repeated frame", func(b []byte) []byte {
+			return append(b[:ends[1]:ends[1]], b[ends[0]:]...)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "corrupt.flac")
+			if err := os.WriteFile(p, tt.corrupt(bytes.Clone(data)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := decode(t, p); !errors.Is(err, errCorrupt) {
+				t.Fatalf("decoding = %v, want a corrupt frame error", err)
+			}
+
+			// Repair keeps the two frames before the damage.
+			if _, err := Repair(p); err != nil {
+				t.Fatalf("Repair: %v", err)
+			}
+			info, got, err := decode(t, p)
+			if err != nil {
+				t.Fatalf("decoding repaired file: %v", err)
+			}
+			if info.Frames != 8192 || info.Size != ends[1] {
+				t.Fatalf("repaired file has %d frames in %d bytes, want 8192 in %d", info.Frames, info.Size, ends[1])
+			}
+			checkDecoded(t, got, quantized(samples[:8192*2], 16))
+		})
+	}
+}
+
+func TestRepairUnclosed(t *testing.T) {
+	tests := []struct {
+		name string
+		cut  int64 // bytes cut from the end of the last frame
+		want int   // complete blocks left
+	}{
+		{"frame boundary", 0, 4},
+		{"mid-frame", 100, 3},
+		{"one byte short", 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "take.flac")
+			w, err := Create(path, 48000, 2, 24, 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples := testSignal(4*4096+1000, 2)
+			if err := w.WriteFrames(samples[:2*4096*2]); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteFrames(samples[2*4096*2:]); err != nil {
+				t.Fatal(err)
+			}
+			// Crash without Close: STREAMINFO still describes two blocks and
+			// the pending 1000 frames never reach the file.
+			size := w.Size()
+			w.f.Close()
+			if err := os.Truncate(path, size-tt.cut); err != nil {
+				t.Fatal(err)
+			}
+
+			repaired, err := Repair(path)
+			if err != nil {
+				t.Fatalf("Repair: %v", err)
+			}
+			if !repaired {
+				t.Fatal("Repair reported nothing to do")
+			}
+			frames := tt.want * 4096
+			info, got, err := decode(t, path)
+			if err != nil {
+				t.Fatalf("decoding repaired file: %v", err)
+			}
+			if info.Frames != int64(frames) {
+				t.Fatalf("repaired file has %d frames, want %d", info.Frames, frames)
+			}
+			checkDecoded(t, got, quantized(samples[:frames*2], 24))
+
+			if again, err := Repair(path); err != nil || again {
+				t.Fatalf("second Repair = %t, %v; want no change", again, err)
+			}
+		})
+	}
+}
+
+func TestReplaceComments(t *testing.T) {
+	samples := testSignal(5000, 2)
+	path := writeFile(t, samples, 2, 16, 5, "TITLE=first")
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comments := []string{"TITLE=second", "COMMENT=" + strings.Repeat("x", 4000)}
+	if err := ReplaceComments(path, comments); err != nil {
+		t.Fatalf("ReplaceComments: %v", err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) || !bytes.Equal(after[audioOffset:], before[audioOffset:]) {
+		t.Fatal("rewriting the comments moved or changed the audio")
+	}
+	if !bytes.Contains(after[:audioOffset], []byte("TITLE=second")) || bytes.Contains(after, []byte("TITLE=first")) {
+		t.Fatal("comments were not replaced")
+	}
+	if _, got, err := decode(t, path); err != nil {
+		t.Fatalf("decoding: %v", err)
+	} else {
+		checkDecoded(t, got, quantized(samples, 16))
+	}
+
+	// Comments that do not fit the reserved space leave the file alone.
+	if err := ReplaceComments(path, []string{"COMMENT=" + strings.Repeat("x", metadataReserve)}); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Fatalf("ReplaceComments with oversized comments = %v, want ErrMetadataTooLarge", err)
+	}
+	if unchanged, _ := os.ReadFile(path); !bytes.Equal(unchanged, after) {
+		t.Fatal("a failed rewrite changed the file")
+	}
+}
@@ -0,0 +1,98 @@
+package flac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// metadataArea encodes the Vorbis comment block followed by the padding that
+// fills the rest of the reserved space. The padding block is the last
+// metadata block.
+func metadataArea(comments []string) ([]byte, error) {
+	body := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	body = append(body, vendor...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(comments)))
+	for _, c := range comments {
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(c)))
+		body = append(body, c...)
+	}
+
+	padding := metadataReserve - 4 - len(body) - 4
+	if padding < 0 {
+		return nil, ErrMetadataTooLarge
+	}
+	area := append(blockHeader(blockVorbisComment, false, len(body)), body...)
+	area = append(area, blockHeader(blockPadding, true, padding)...)
+	return append(area, make([]byte, padding)...), nil
+}
+
+// ReplaceComments rewrites the Vorbis comments of a file written by this
+// package in the space reserved for them. The audio is not touched.
+func ReplaceComments(path string, comments []string) error {
+	area, err := metadataArea(comments)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, audio, err := readStreamInfo(f); err != nil {
+		return err
+	} else if audio != audioOffset {
+		return fmt.Errorf("flac: %s was not written by this package", path)
+	}
+	if _, err := f.WriteAt(area, streamInfoOffset+streamInfoSize); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// streamInfo is the decoded STREAMINFO block.
+type streamInfo struct {
+	minBlockSize  int
+	maxBlockSize  int
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	totalSamples  int64
+}
+
+// readStreamInfo decodes STREAMINFO and walks the metadata blocks to find
+// where the audio frames start.
+func readStreamInfo(f io.ReaderAt) (streamInfo, int64, error) {
+	head := make([]byte, streamInfoOffset+streamInfoSize)
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return streamInfo{}, 0, fmt.Errorf("flac: reading header: %w", err)
+	}
+	if string(head[0:4]) != "fLaC" || head[4]&0x7F != blockStreamInfo {
+		return streamInfo{}, 0, fmt.Errorf("flac: not a FLAC file")
+	}
+	b := head[streamInfoOffset:]
+	v := binary.BigEndian.Uint64(b[10:18])
+	si := streamInfo{
+		minBlockSize:  int(binary.BigEndian.Uint16(b[0:2])),
+		maxBlockSize:  int(binary.BigEndian.Uint16(b[2:4])),
+		sampleRate:    int(v >> 44),
+		channels:      int(v>>41&7) + 1,
+		bitsPerSample: int(v>>36&31) + 1,
+		totalSamples:  int64(v & maxTotalSamples),
+	}
+
+	offset := int64(4)
+	for {
+		var h [4]byte
+		if _, err := f.ReadAt(h[:], offset); err != nil {
+			return si, 0, fmt.Errorf("flac: reading metadata: %w", err)
+		}
+		offset += 4 + (int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3]))
+		if h[0]&0x80 != 0 {
+			return si, offset, nil
+		}
+	}
+}
@@ -0,0 +1,589 @@
+package flac
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// Info describes the format and length of a FLAC file.
+type Info struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	Frames        int64 // 0 if the stream does not record its length
+	Duration      time.Duration
+	Size          int64
+}
+
+// Stat reads the STREAMINFO of the FLAC file at path.
+func Stat(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	info, _, err := readInfo(f)
+	return info, err
+}
+
+func readInfo(f *os.File) (Info, int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return Info{}, 0, err
+	}
+	si, audio, err := readStreamInfo(f)
+	if err != nil {
+		return Info{}, 0, err
+	}
+	if si.sampleRate == 0 {
+		return Info{}, 0, fmt.Errorf("flac: invalid sample rate")
+	}
+	return Info{
+		SampleRate:    si.sampleRate,
+		Channels:      si.channels,
+		BitsPerSample: si.bitsPerSample,
+		Frames:        si.totalSamples,
+		Duration:      time.Duration(si.totalSamples) * time.Second / time.Duration(si.sampleRate),
+		Size:          st.Size(),
+	}, audio, nil
+}
+
+// errCorrupt is returned when a frame fails to decode or its checksum does not match.
+var errCorrupt = errors.New("flac: corrupt frame")
+
+// Reader decodes the frames of a FLAC file.
+type Reader struct {
+	f     *os.File
+	info  Info
+	audio int64 // offset of the first frame
+	br    bitReader
+	block [][]int32 // decoded samples of the current frame, per channel
+	next  int       // index of the next unread frame in block
+	pos   int64     // frames read so far
+	num   uint64    // number of the next FLAC frame
+}
+
+// Open opens the FLAC file at path for reading.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, audio, err := readInfo(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &Reader{f: f, info: info, audio: audio}
+	r.Rewind()
+	return r, nil
+}
+
+// Info returns the format of the file.
+func (r *Reader) Info() Info { return r.info }
+
+// ReadFrames decodes up to len(buf)/Channels frames into buf as floats in
+// [-1, 1] and returns the number of frames read, or io.EOF at the end of the stream.
+func (r *Reader) ReadFrames(buf []float32) (int, error) {
+	if r.info.Frames > 0 && r.pos >= r.info.Frames {
+		return 0, io.EOF
+	}
+	if r.next >= len(r.block[0]) {
+		if err := r.decodeFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	channels := r.info.Channels
+	n := min(len(buf)/channels, len(r.block[0])-r.next)
+	if r.info.Frames > 0 {
+		n = int(min(int64(n), r.info.Frames-r.pos))
+	}
+	scale := float32(int64(1) << (r.info.BitsPerSample - 1))
+	for i := range n {
+		for c := range channels {
+			buf[i*channels+c] = float32(r.block[c][r.next+i]) / scale
+		}
+	}
+	r.next += n
+	r.pos += int64(n)
+	return n, nil
+}
+
+// Rewind moves the read position back to the first frame.
+func (r *Reader) Rewind() {
+	r.br = bitReader{r: bufio.NewReaderSize(io.NewSectionReader(r.f, r.audio, 1<<62), 64<<10)}
+	r.block = make([][]int32, r.info.Channels)
+	r.next, r.pos, r.num = 0, 0, 0
+}
+
+// Close closes the underlying file.
+func (r *Reader) Close() error { return r.f.Close() }
+
+// decodeFrame decodes the next frame into r.block.
+func (r *Reader) decodeFrame() error {
+	if _, err := readFrame(&r.br, r.info, r.block, r.num); err != nil {
+		return err
+	}
+	r.next = 0
+	r.num++
+	return nil
+}
+
+// readFrame decodes the next frame into block, resizing its per-channel
+// slices to the frame's block size, and checks its CRCs and, in a stream of
+// fixed block size, that it is frame number num. It returns io.EOF if the
+// stream ends cleanly before the frame.
+func readFrame(br *bitReader, info Info, block [][]int32, num uint64) (frameHeader, error) {
+	br.crc8, br.crc16 = 0, 0
+	h, err := readFrameHeader(br, info)
+	if err != nil {
+		return h, err
+	}
+	if !h.variable && h.number != num {
+		return h, fmt.Errorf("%w: frame %d where %d was expected", errCorrupt, h.number, num)
+	}
+	if h.channels != info.Channels {
+		return h, fmt.Errorf("%w: channel count changed", errCorrupt)
+	}
+
+	for c := range h.channels {
+		bps := h.bitsPerSample
+		switch {
+		case c == 1 && (h.assignment == channelLeftSide || h.assignment == channelMidSide),
+			c == 0 && h.assignment == channelSideRight:
+			bps++ // side channel
+		}
+		if cap(block[c]) < h.blockSize {
+			block[c] = make([]int32, h.blockSize)
+		}
+		block[c] = block[c][:h.blockSize]
+		if err := decodeSubframe(br, block[c], uint(bps)); err != nil {
+			return h, err
+		}
+	}
+	br.align()
+	crc := br.crc16
+	want, err := br.readBits(16)
+	if err != nil {
+		return h, err
+	}
+	if uint16(want) != crc {
+		return h, fmt.Errorf("%w: CRC mismatch", errCorrupt)
+	}
+
+	restoreStereo(block, h.assignment)
+	return h, nil
+}
+
+// frameHeader is a decoded frame header.
+type frameHeader struct {
+	blockSize     int
+	sampleRate    int
+	assignment    int
+	channels      int
+	bitsPerSample int
+	number        uint64 // sample number instead if variable
+	variable      bool   // the stream has a variable block size
+}
+
+// readFrameHeader reads and checks a frame header. It returns io.EOF if the
+// stream ends cleanly before the header.
+func readFrameHeader(br *bitReader, info Info) (frameHeader, error) {
+	start := br.consumed
+	sync, err := br.readBits(15)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) && br.consumed == start {
+			return frameHeader{}, io.EOF
+		}
+		return frameHeader{}, err
+	}
+	if sync != 0xFFF8>>1 {
+		return frameHeader{}, fmt.Errorf("%w: lost sync", errCorrupt)
+	}
+	fields, err := br.readBits(17)
+	if err != nil {
+		return frameHeader{}, err
+	}
+	bsCode := int(fields >> 12 & 0xF)
+	srCode := int(fields >> 8 & 0xF)
+	assignment := int(fields >> 4 & 0xF)
+	sizeCode := int(fields >> 1 & 0x7)
+
+	h := frameHeader{assignment: assignment, sampleRate: info.SampleRate, bitsPerSample: info.BitsPerSample, variable: fields>>16 == 1}
+	if h.number, err = br.readUTF8(); err != nil {
+		return h, err
+	}
+	switch {
+	case bsCode == 1:
+		h.blockSize = 192
+	case bsCode >= 2 && bsCode <= 5:
+		h.blockSize = 576 << (bsCode - 2)
+	case bsCode == 6 || bsCode == 7:
+		v, err := br.readBits(uint(8 * (bsCode - 5)))
+		if err != nil {
+			return h, err
+		}
+		h.blockSize = int(v) + 1
+	case bsCode >= 8:
+		h.blockSize = 256 << (bsCode - 8)
+	default:
+		return h, fmt.Errorf("%w: reserved block size", errCorrupt)
+	}
+	switch srCode {
+	case 12:
+		v, err := br.readBits(8)
+		if err != nil {
+			return h, err
+		}
+		h.sampleRate = int(v) * 1000
+	case 13, 14:
+		v, err := br.readBits(16)
+		if err != nil {
+			return h, err
+		}
+		h.sampleRate = int(v)
+		if srCode == 14 {
+			h.sampleRate *= 10
+		}
+	case 15:
+		return h, fmt.Errorf("%w: invalid sample rate", errCorrupt)
+	}
+	switch sizeCode {
+	case 1:
+		h.bitsPerSample = 8
+	case 2:
+		h.bitsPerSample = 12
+	case 4:
+		h.bitsPerSample = 16
+	case 5:
+		h.bitsPerSample = 20
+	case 6:
+		h.bitsPerSample = 24
+	case 7:
+		h.bitsPerSample = 32
+	}
+	switch {
+	case assignment < 8:
+		h.channels = assignment + 1
+	case assignment <= channelMidSide:
+		h.channels = 2
+	default:
+		return h, fmt.Errorf("%w: reserved channel assignment", errCorrupt)
+	}
+
+	crc := br.crc8
+	want, err := br.readBits(8)
+	if err != nil {
+		return h, err
+	}
+	if uint8(want) != crc {
+		return h, fmt.Errorf("%w: header CRC mismatch", errCorrupt)
+	}
+	return h, nil
+}
+
+// decodeSubframe decodes one channel of a frame into out.
+func decodeSubframe(br *bitReader, out []int32, bps uint) error {
+	hdr, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	if hdr&0x80 != 0 {
+		return fmt.Errorf("%w: subframe padding bit set", errCorrupt)
+	}
+	typ := int(hdr >> 1 & 0x3F)
+	wasted := uint(0)
+	if hdr&1 != 0 {
+		k, err := br.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = uint(k) + 1
+		bps -= wasted
+	}
+
+	n := len(out)
+	switch {
+	case typ == subframeConstant:
+		v, err := br.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = int32(v)
+		}
+	case typ == subframeVerbatim:
+		for i := range out {
+			v, err := br.readSigned(bps)
+			if err != nil {
+				return err
+			}
+			out[i] = int32(v)
+		}
+	case typ >= subframeFixed && typ <= subframeFixed|maxFixedOrder:
+		order := typ & 7
+		if order > n {
+			return fmt.Errorf("%w: predictor order exceeds block size", errCorrupt)
+		}
+		for i := range order {
+			v, err := br.readSigned(bps)
+			if err != nil {
+				return err
+			}
+			out[i] = int32(v)
+		}
+		if err := readResidual(br, out, order); err != nil {
+			return err
+		}
+		restoreFixed(out, order)
+	case typ >= subframeLPC:
+		order := typ&0x1F + 1
+		if order > n {
+			return fmt.Errorf("%w: predictor order exceeds block size", errCorrupt)
+		}
+		for i := range order {
+			v, err := br.readSigned(bps)
+			if err != nil {
+				return err
+			}
+			out[i] = int32(v)
+		}
+		p, err := br.readBits(4)
+		if err != nil {
+			return err
+		}
+		if p == 15 {
+			return fmt.Errorf("%w: invalid coefficient precision", errCorrupt)
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return fmt.Errorf("%w: negative predictor shift", errCorrupt)
+		}
+		coefs := make([]int64, order)
+		for i := range coefs {
+			if coefs[i], err = br.readSigned(uint(p) + 1); err != nil {
+				return err
+			}
+		}
+		if err := readResidual(br, out, order); err != nil {
+			return err
+		}
+		for i := order; i < n; i++ {
+			var sum int64
+			for j, c := range coefs {
+				sum += c * int64(out[i-1-j])
+			}
+			out[i] += int32(sum >> shift)
+		}
+	default:
+		return fmt.Errorf("%w: reserved subframe type %d", errCorrupt, typ)
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// readResidual decodes the partitioned Rice residual into out[order:].
+func readResidual(br *bitReader, out []int32, order int) error {
+	method, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return fmt.Errorf("%w: reserved residual coding", errCorrupt)
+	}
+	paramBits := uint(4 + method)
+	escape := uint64(1)<<paramBits - 1
+
+	po, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+	size := len(out) >> po
+	if size<<po != len(out) || size < order {
+		return fmt.Errorf("%w: invalid partition order", errCorrupt)
+	}
+	for p := range 1 << po {
+		start := max(p*size, order)
+		k, err := br.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+		if k == escape {
+			width, err := br.readBits(5)
+			if err != nil {
+				return err
+			}
+			for i := start; i < (p+1)*size; i++ {
+				v, err := br.readSigned(uint(width))
+				if err != nil {
+					return err
+				}
+				out[i] = int32(v)
+			}
+			continue
+		}
+		for i := start; i < (p+1)*size; i++ {
+			q, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := br.readBits(uint(k))
+			if err != nil {
+				return err
+			}
+			u := q<<k | low
+			out[i] = int32(u>>1) ^ -int32(u&1)
+		}
+	}
+	return nil
+}
+
+// restoreFixed turns the residual in out[order:] back into samples.
+func restoreFixed(out []int32, order int) {
+	for i := order; i < len(out); i++ {
+		switch order {
+		case 1:
+			out[i] += out[i-1]
+		case 2:
+			out[i] += 2*out[i-1] - out[i-2]
+		case 3:
+			out[i] += 3*out[i-1] - 3*out[i-2] + out[i-3]
+		case 4:
+			out[i] += 4*out[i-1] - 6*out[i-2] + 4*out[i-3] - out[i-4]
+		}
+	}
+}
+
+// restoreStereo undoes inter-channel decorrelation.
+func restoreStereo(block [][]int32, assignment int) {
+	switch assignment {
+	case channelLeftSide:
+		for i, s := range block[1] {
+			block[1][i] = block[0][i] - s
+		}
+	case channelSideRight:
+		for i, s := range block[0] {
+			block[0][i] = block[1][i] + s
+		}
+	case channelMidSide:
+		for i, s := range block[1] {
+			mid := int64(block[0][i])<<1 | int64(s)&1
+			block[0][i] = int32((mid + int64(s)) >> 1)
+			block[1][i] = int32((mid - int64(s)) >> 1)
+		}
+	}
+}
+
+// bitReader reads MSB-first bit fields, keeping the running header and frame CRCs.
+type bitReader struct {
+	r        *bufio.Reader
+	acc      uint64
+	n        uint
+	crc8     uint8
+	crc16    uint16
+	consumed int64
+}
+
+func (b *bitReader) fill() error {
+	c, err := b.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	b.acc = b.acc<<8 | uint64(c)
+	b.n += 8
+	b.crc8 = crc8Table[b.crc8^c]
+	b.crc16 = b.crc16<<8 ^ crc16Table[byte(b.crc16>>8)^c]
+	b.consumed++
+	return nil
+}
+
+func (b *bitReader) readBits(n uint) (uint64, error) {
+	for b.n < n {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	b.n -= n
+	v := b.acc >> b.n & (1<<n - 1)
+	b.acc &= 1<<b.n - 1
+	return v, nil
+}
+
+func (b *bitReader) readSigned(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := b.readBits(n)
+	return int64(v<<(64-n)) >> (64 - n), err
+}
+
+// readUnary counts zero bits up to and including the terminating one.
+func (b *bitReader) readUnary() (uint64, error) {
+	var q uint64
+	for {
+		if b.n == 0 {
+			if err := b.fill(); err != nil {
+				return 0, err
+			}
+		}
+		if b.acc == 0 {
+			q += uint64(b.n)
+			b.n = 0
+			continue
+		}
+		lz := b.n - uint(bits.Len64(b.acc))
+		q += uint64(lz)
+		b.n -= lz + 1
+		b.acc &= 1<<b.n - 1
+		return q, nil
+	}
+}
+
+// readUTF8 reads a frame number in the extended UTF-8 coding.
+func (b *bitReader) readUTF8() (uint64, error) {
+	first, err := b.readBits(8)
+	if err != nil {
+		return 0, err
+	}
+	n := bits.LeadingZeros8(^uint8(first))
+	switch {
+	case n == 0:
+		return first, nil
+	case n == 1 || n > 7:
+		return 0, fmt.Errorf("%w: invalid frame number", errCorrupt)
+	}
+	v := first & (0x7F >> n)
+	for range n - 1 {
+		c, err := b.readBits(8)
+		if err != nil {
+			return 0, err
+		}
+		if c&0xC0 != 0x80 {
+			return 0, fmt.Errorf("%w: invalid frame number", errCorrupt)
+		}
+		v = v<<6 | c&0x3F
+	}
+	return v, nil
+}
+
+func (b *bitReader) align() {
+	b.n = 0
+	b.acc = 0
+}
@@ -0,0 +1,72 @@
+package flac
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Repair fixes up a FLAC file that was not closed, e.g. after a crash: any
+// partially written trailing frame is dropped and STREAMINFO is rewritten to
+// describe the complete frames. It reports whether the file was modified.
+func Repair(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, audio, err := readInfo(f)
+	if err != nil {
+		return false, err
+	}
+
+	// Decode every frame; the last one that checks out marks the end of the audio.
+	br := bitReader{r: bufio.NewReaderSize(io.NewSectionReader(f, audio, info.Size-audio), 64<<10)}
+	block := make([][]int32, info.Channels)
+	var total int64
+	var end int64
+	var num uint64
+	minFrame, maxFrame := 0, 0
+	for {
+		h, err := readFrame(&br, info, block, num)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errCorrupt) {
+				break
+			}
+			return false, err
+		}
+		size := int(br.consumed - end)
+		end = br.consumed
+		total += int64(h.blockSize)
+		num++
+		if minFrame == 0 || size < minFrame {
+			minFrame = size
+		}
+		maxFrame = max(maxFrame, size)
+	}
+
+	if total == info.Frames && audio+end == info.Size {
+		return false, nil
+	}
+	if err := f.Truncate(audio + end); err != nil {
+		return false, err
+	}
+
+	b := make([]byte, streamInfoSize)
+	if _, err := f.ReadAt(b, streamInfoOffset); err != nil {
+		return false, fmt.Errorf("flac: reading STREAMINFO: %w", err)
+	}
+	putUint24(b[4:7], minFrame)
+	putUint24(b[7:10], maxFrame)
+	v := binary.BigEndian.Uint64(b[10:18])&^maxTotalSamples | uint64(total)
+	binary.BigEndian.PutUint64(b[10:18], v)
+	clear(b[18:34]) // the signature of the truncated stream is unknown
+	if _, err := f.WriteAt(b, streamInfoOffset); err != nil {
+		return false, err
+	}
+	return true, f.Sync()
+}
@@ -0,0 +1,66 @@
+package flac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Snapshot is a read-only view of the frames written so far to a file that is
+// still being recorded. Its STREAMINFO is patched to describe exactly the
+// frames in the view, so it is a complete FLAC file even though the writer
+// only updates STREAMINFO on disk at each Sync.
+type Snapshot struct {
+	*io.SectionReader
+	f *os.File
+}
+
+// OpenSnapshot opens path and exposes its first size bytes, which must hold
+// exactly frames sample frames in complete FLAC frames.
+func OpenSnapshot(path string, size, frames int64) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := make([]byte, streamInfoOffset+streamInfoSize)
+	if _, err := io.ReadFull(f, h); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("flac: reading header: %w", err)
+	}
+	if string(h[0:4]) != "fLaC" || h[4]&0x7F != blockStreamInfo {
+		f.Close()
+		return nil, fmt.Errorf("flac: %s is not a FLAC file", path)
+	}
+	b := h[streamInfoOffset:]
+	v := binary.BigEndian.Uint64(b[10:18])&^maxTotalSamples | uint64(frames)&maxTotalSamples
+	binary.BigEndian.PutUint64(b[10:18], v)
+
+	r := &snapshotReader{header: h, f: f}
+	return &Snapshot{SectionReader: io.NewSectionReader(r, 0, size), f: f}, nil
+}
+
+// Close closes the underlying file.
+func (s *Snapshot) Close() error {
+	return s.f.Close()
+}
+
+// snapshotReader serves the patched STREAMINFO from memory and everything
+// after it from the file.
+type snapshotReader struct {
+	header []byte
+	f      *os.File
+}
+
+func (r *snapshotReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	if off < int64(len(r.header)) {
+		n = copy(p, r.header[off:])
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	m, err := r.f.ReadAt(p[n:], off+int64(n))
+	return n + m, err
+}
@@ -0,0 +1,275 @@
+// Package loudness measures audio loudness as specified by EBU R128 and
+// ITU-R BS.1770: integrated loudness, loudness range, sample peak and true
+// peak.
+package loudness
+
+import (
+	"math"
+	"slices"
+)
+
+const (
+	// AbsoluteGate is the level below which blocks are ignored, in LUFS. It
+	// is also the integrated loudness reported for silence.
+	AbsoluteGate = -70.0
+	// MinLevel is the lowest peak level reported, in dBFS, for silence.
+	MinLevel = -144.0
+
+	integratedRelativeGate = -10.0 // LU below the absolute-gated loudness
+	rangeRelativeGate      = -20.0
+
+	subBlocksPerSecond  = 10 // blocks advance in 100 ms steps
+	momentarySubBlocks  = 4  // 400 ms gating blocks, 75% overlap
+	shortTermSubBlocks  = 30 // 3 s blocks for the loudness range
+	rangeLowPercentile  = 0.10
+	rangeHighPercentile = 0.95
+)
+
+// Result holds the measurements of a whole programme.
+type Result struct {
+	Integrated float64 // integrated loudness in LUFS, AbsoluteGate for silence
+	Range      float64 // loudness range in LU
+	SamplePeak float64 // highest sample in dBFS, MinLevel for silence
+	TruePeak   float64 // highest inter-sample peak in dBTP, MinLevel for silence
+}
+
+// Meter accumulates the measurements of interleaved float32 audio written to
+// it in blocks of any size.
+type Meter struct {
+	channels  int
+	weights   []float64
+	filters   []kFilter
+	truePeaks []*truePeakMeter
+
+	subLength int       // frames per 100 ms step
+	subFrames int       // frames in the current step
+	subEnergy float64   // weighted sum of squares of the current step
+	recent    []float64 // energies of the latest steps, newest last
+	steps     int
+
+	momentary  []float64 // mean square of each 400 ms block
+	shortTerm  []float64 // mean square of each 3 s block
+	samplePeak float64
+}
+
+// NewMeter returns a meter for audio with the given format.
+func NewMeter(sampleRate, channels int) *Meter {
+	m := &Meter{
+		channels:  channels,
+		weights:   channelWeights(channels),
+		filters:   make([]kFilter, channels),
+		truePeaks: make([]*truePeakMeter, channels),
+		subLength: max(sampleRate/subBlocksPerSecond, 1),
+		recent:    make([]float64, 0, shortTermSubBlocks),
+	}
+	for c := range channels {
+		m.filters[c] = newKFilter(float64(sampleRate))
+		m.truePeaks[c] = newTruePeakMeter(sampleRate)
+	}
+	return m
+}
+
+// channelWeights returns the BS.1770 weighting of each channel. Only 5.1
+// audio has surround channels to boost and an LFE channel to leave out.
+func channelWeights(channels int) []float64 {
+	w := make([]float64, channels)
+	for c := range w {
+		w[c] = 1
+	}
+	if channels == 6 { // L R C LFE Ls Rs
+		w[3], w[4], w[5] = 0, 1.41, 1.41
+	}
+	return w
+}
+
+// Write adds interleaved samples to the measurement.
+func (m *Meter) Write(samples []float32) {
+	for i := 0; i+m.channels <= len(samples); i += m.channels {
+		for c := range m.channels {
+			x := float64(samples[i+c])
+			m.samplePeak = max(m.samplePeak, math.Abs(x))
+			m.truePeaks[c].write(x)
+			if m.weights[c] != 0 {
+				y := m.filters[c].process(x)
+				m.subEnergy += m.weights[c] * y * y
+			}
+		}
+		if m.subFrames++; m.subFrames == m.subLength {
+			m.endStep()
+		}
+	}
+}
+
+// endStep completes a 100 ms step and records the blocks ending with it.
+func (m *Meter) endStep() {
+	if len(m.recent) == shortTermSubBlocks {
+		m.recent = append(m.recent[:0], m.recent[1:]...)
+	}
+	m.recent = append(m.recent, m.subEnergy)
+	m.subEnergy, m.subFrames = 0, 0
+	m.steps++
+
+	if m.steps >= momentarySubBlocks {
+		m.momentary = append(m.momentary, m.meanSquare(momentarySubBlocks))
+	}
+	if m.steps >= shortTermSubBlocks {
+		m.shortTerm = append(m.shortTerm, m.meanSquare(shortTermSubBlocks))
+	}
+}
+
+// meanSquare returns the weighted mean square over the latest n steps.
+func (m *Meter) meanSquare(n int) float64 {
+	var sum float64
+	for _, e := range m.recent[len(m.recent)-n:] {
+		sum += e
+	}
+	return sum / float64(n*m.subLength)
+}
+
+// Result returns the measurements of everything written so far. Audio shorter
+// than one 400 ms block has no loudness; a programme too short or too uniform
+// for the short-term blocks has a loudness range of 0.
+func (m *Meter) Result() Result {
+	r := Result{
+		Integrated: AbsoluteGate,
+		SamplePeak: level(m.samplePeak),
+	}
+	truePeak := m.samplePeak
+	for _, tp := range m.truePeaks {
+		truePeak = max(truePeak, tp.peak)
+	}
+	r.TruePeak = level(truePeak)
+
+	if gated := gate(m.momentary, integratedRelativeGate); len(gated) > 0 {
+		r.Integrated = max(loudness(mean(gated)), AbsoluteGate)
+	}
+	if gated := gate(m.shortTerm, rangeRelativeGate); len(gated) > 1 {
+		levels := make([]float64, len(gated))
+		for i, e := range gated {
+			levels[i] = loudness(e)
+		}
+		slices.Sort(levels)
+		r.Range = percentile(levels, rangeHighPercentile) - percentile(levels, rangeLowPercentile)
+	}
+	return r
+}
+
+// gate returns the blocks above the absolute gate and then above the
+// relative gate, offset LU below the loudness of those.
+func gate(blocks []float64, offset float64) []float64 {
+	absolute := energy(AbsoluteGate)
+	var above []float64
+	for _, e := range blocks {
+		if e > absolute {
+			above = append(above, e)
+		}
+	}
+	if len(above) == 0 {
+		return nil
+	}
+	relative := energy(loudness(mean(above)) + offset)
+	gated := above[:0]
+	for _, e := range above {
+		if e > relative {
+			gated = append(gated, e)
+		}
+	}
+	return gated
+}
+
+// loudness converts a weighted mean square to LUFS.
+func loudness(e float64) float64 {
+	return -0.691 + 10*math.Log10(e)
+}
+
+// energy is the inverse of loudness.
+func energy(lufs float64) float64 {
+	return math.Pow(10, (lufs+0.691)/10)
+}
+
+// level converts a linear peak to dB, floored at MinLevel.
+func level(peak float64) float64 {
+	if peak <= 0 {
+		return MinLevel
+	}
+	return max(20*math.Log10(peak), MinLevel)
+}
+
+func mean(v []float64) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
+
+// percentile interpolates the p-th quantile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// biquad is a second-order IIR section in direct form I.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kFilter is the BS.1770 K-weighting: a high shelf modelling the head
+// followed by a high-pass filter.
+type kFilter struct {
+	shelf, highPass biquad
+}
+
+// newKFilter derives the K-weighting coefficients for a sample rate. At
+// 48 kHz they match the ones tabulated in BS.1770.
+func newKFilter(sampleRate float64) kFilter {
+	var f kFilter
+
+	const (
+		shelfFreq = 1681.974450955533
+		shelfGain = 3.999843853973347 // dB
+		shelfQ    = 0.7071752369554196
+	)
+	k := math.Tan(math.Pi * shelfFreq / sampleRate)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	f.shelf = biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	const (
+		highPassFreq = 38.13547087602444
+		highPassQ    = 0.5003270373238773
+	)
+	k = math.Tan(math.Pi * highPassFreq / sampleRate)
+	a0 = 1 + k/highPassQ + k*k
+	f.highPass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highPassQ + k*k) / a0,
+	}
+	return f
+}
+
+func (f *kFilter) process(x float64) float64 {
+	return f.highPass.process(f.shelf.process(x))
+}
@@ -0,0 +1,118 @@
+package loudness
+
+import (
+	"math"
+	"testing"
+)
+
+// segment is a stretch of a stereo sine at a level in dBFS, where 0 dBFS is
+// a full-scale sine.
+type segment struct {
+	dbfs float64
+	secs float64
+}
+
+// measure writes the segments, as a continuous sine of freq at sampleRate in
+// both channels, and returns the result. phase offsets the sine in radians.
+func measure(sampleRate int, freq, phase float64, segments ...segment) Result {
+	m := NewMeter(sampleRate, 2)
+	var n int
+	for _, s := range segments {
+		amplitude := math.Pow(10, s.dbfs/20)
+		block := make([]float32, 0, 2*sampleRate/10)
+		for range int(s.secs * float64(sampleRate)) {
+			v := float32(amplitude * math.Sin(2*math.Pi*freq*float64(n)/float64(sampleRate)+phase))
+			block = append(block, v, v)
+			if n++; len(block) == cap(block) {
+				m.Write(block)
+				block = block[:0]
+			}
+		}
+		m.Write(block)
+	}
+	return m.Result()
+}
+
+func within(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+// TestIntegratedLoudness follows the stereo cases of EBU Tech 3341, which
+// must read within ±0.1 LU.
+func TestIntegratedLoudness(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate int
+		segments   []segment
+		want       float64
+	}{
+		{"-20 dBFS", 48000, []segment{{-20, 20}}, -20},
+		{"-20 dBFS at 44.1 kHz", 44100, []segment{{-20, 20}}, -20},
+		{"-23 dBFS", 48000, []segment{{-23, 20}}, -23},
+		{"-33 dBFS", 48000, []segment{{-33, 20}}, -33},
+		// Relative gate: the quiet ends are more than 10 LU below.
+		{"-36/-23/-36 dBFS", 48000, []segment{{-36, 10}, {-23, 60}, {-36, 10}}, -23},
+		// Absolute gate: the -72 dBFS stretches fall below -70 LUFS.
+		{"-72/-36/-23/-36/-72 dBFS", 48000, []segment{{-72, 10}, {-36, 10}, {-23, 60}, {-36, 10}, {-72, 10}}, -23},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := measure(tt.sampleRate, 997, 0, tt.segments...)
+			if !within(r.Integrated, tt.want, 0.1) {
+				t.Fatalf("integrated loudness = %.2f LUFS, want %.1f", r.Integrated, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoudnessRange follows the cases of EBU Tech 3342, which must read
+// within ±1 LU.
+func TestLoudnessRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []segment
+		want     float64
+	}{
+		{"-20/-30 dBFS", []segment{{-20, 20}, {-30, 20}}, 10},
+		{"-20/-15 dBFS", []segment{{-20, 20}, {-15, 20}}, 5},
+		{"-40/-20 dBFS", []segment{{-40, 20}, {-20, 20}}, 20},
+		{"-50/-35/-20/-35/-50 dBFS", []segment{{-50, 20}, {-35, 20}, {-20, 20}, {-35, 20}, {-50, 20}}, 15},
+		{"steady tone", []segment{{-20, 20}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := measure(48000, 1000, 0, tt.segments...)
+			if !within(r.Range, tt.want, 1) {
+				t.Fatalf("loudness range = %.2f LU, want %.0f", r.Range, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruePeak(t *testing.T) {
+	// A sine at a quarter of the sample rate, 45° out of phase with the
+	// samples, peaks between them 3 dB above the highest sample.
+	r := measure(48000, 12000, math.Pi/4, segment{0, 1})
+	if !within(r.SamplePeak, -3.01, 0.05) {
+		t.Fatalf("sample peak = %.2f dBFS, want -3.01", r.SamplePeak)
+	}
+	// Tech 3341 allows -0.4 to +0.2 dB.
+	if r.TruePeak < -0.4 || r.TruePeak > 0.2 {
+		t.Fatalf("true peak = %.2f dBTP, want 0", r.TruePeak)
+	}
+
+	// Without inter-sample peaks the two agree.
+	r = measure(48000, 997, 0, segment{-6, 1})
+	if !within(r.TruePeak, r.SamplePeak, 0.1) {
+		t.Fatalf("true peak = %.2f dBTP for a sample peak of %.2f dBFS", r.TruePeak, r.SamplePeak)
+	}
+}
+
+func TestSilence(t *testing.T) {
+	m := NewMeter(48000, 2)
+	m.Write(make([]float32, 2*48000))
+	r := m.Result()
+	if r.Integrated != AbsoluteGate || r.Range != 0 || r.SamplePeak != MinLevel || r.TruePeak != MinLevel {
+		t.Fatalf("silence measured as %+v", r)
+	}
+}
@@ -0,0 +1,88 @@
+package loudness
+
+import "math"
+
+// tapsPerPhase is the length of each polyphase branch of the oversampling
+// filter; BS.1770 suggests 48 taps in total at 4x.
+const tapsPerPhase = 12
+
+// truePeakMeter estimates the peak of the continuous signal behind one
+// channel by oversampling it, as described in BS.1770 Annex 2. Signals at
+// 96 kHz are oversampled 2x, and those at 192 kHz and above not at all.
+type truePeakMeter struct {
+	phases  [][]float64 // filter coefficients of each output phase
+	history []float64   // latest input samples, ring buffer
+	pos     int
+	peak    float64
+}
+
+func newTruePeakMeter(sampleRate int) *truePeakMeter {
+	factor := 4
+	switch {
+	case sampleRate >= 192000:
+		factor = 1
+	case sampleRate >= 96000:
+		factor = 2
+	}
+	t := &truePeakMeter{history: make([]float64, tapsPerPhase)}
+	if factor > 1 {
+		t.phases = interpolationFilter(factor)
+	}
+	return t
+}
+
+// interpolationFilter designs a Blackman-windowed sinc low-pass filter
+// cutting off at the input Nyquist frequency and splits it into one branch
+// per output phase.
+func interpolationFilter(factor int) [][]float64 {
+	n := factor * tapsPerPhase
+	center := float64(n-1) / 2
+	phases := make([][]float64, factor)
+	for p := range phases {
+		phases[p] = make([]float64, tapsPerPhase)
+	}
+	for i := range n {
+		x := (float64(i) - center) / float64(factor)
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1)) + 0.08*math.Cos(4*math.Pi*float64(i)/float64(n-1))
+		phases[i%factor][i/factor] = sinc * w
+	}
+	// Normalize each branch to unity gain at DC.
+	for _, h := range phases {
+		var sum float64
+		for _, c := range h {
+			sum += c
+		}
+		for j := range h {
+			h[j] /= sum
+		}
+	}
+	return phases
+}
+
+// write feeds one input sample and updates the peak with the interpolated
+// samples it completes.
+func (t *truePeakMeter) write(x float64) {
+	if t.phases == nil {
+		t.peak = max(t.peak, math.Abs(x))
+		return
+	}
+	t.history[t.pos] = x
+	for _, h := range t.phases {
+		var y float64
+		idx := t.pos
+		for _, c := range h {
+			y += c * t.history[idx]
+			if idx--; idx < 0 {
+				idx = len(t.history) - 1
+			}
+		}
+		t.peak = max(t.peak, math.Abs(y))
+	}
+	if t.pos++; t.pos == len(t.history) {
+		t.pos = 0
+	}
+}
@@ -0,0 +1,128 @@
+package peaks
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// version is the audiowaveform format version written, the first to support
+// more than one channel.
+const version = 2
+
+// flag8Bit marks 8-bit data in the flags of a binary header.
+const flag8Bit = 1
+
+// jsonPeaks is the audiowaveform JSON layout.
+type jsonPeaks struct {
+	Version         int     `json:"version"`
+	Channels        int     `json:"channels"`
+	SampleRate      int     `json:"sample_rate"`
+	SamplesPerPixel int     `json:"samples_per_pixel"`
+	Bits            int     `json:"bits"`
+	Length          int     `json:"length"`
+	Data            []int16 `json:"data"`
+}
+
+// scale returns the data at the given resolution in bits, 8 or 16.
+func (p *Peaks) scale(bits int) ([]int16, error) {
+	switch bits {
+	case 16:
+		return p.Data, nil
+	case 8:
+		data := make([]int16, len(p.Data))
+		for i, v := range p.Data {
+			data[i] = v >> 8
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("peaks: unsupported resolution of %d bits", bits)
+}
+
+// WriteJSON writes p in audiowaveform's JSON format with 8 or 16 bit values.
+func (p *Peaks) WriteJSON(w io.Writer, bits int) error {
+	data, err := p.scale(bits)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(jsonPeaks{
+		Version:         version,
+		Channels:        p.Channels,
+		SampleRate:      p.SampleRate,
+		SamplesPerPixel: p.SamplesPerPixel,
+		Bits:            bits,
+		Length:          p.Length(),
+		Data:            data,
+	})
+}
+
+// WriteBinary writes p in audiowaveform's binary format with 8 or 16 bit values.
+func (p *Peaks) WriteBinary(w io.Writer, bits int) error {
+	data, err := p.scale(bits)
+	if err != nil {
+		return err
+	}
+	var flags uint32
+	if bits == 8 {
+		flags = flag8Bit
+	}
+	bw := bufio.NewWriter(w)
+	header := []uint32{version, flags, uint32(p.SampleRate), uint32(p.SamplesPerPixel), uint32(p.Length()), uint32(p.Channels)}
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if bits == 8 {
+		b := make([]byte, len(data))
+		for i, v := range data {
+			b[i] = byte(int8(v))
+		}
+		_, err = bw.Write(b)
+	} else {
+		err = binary.Write(bw, binary.LittleEndian, data)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadBinary reads peaks in audiowaveform's binary format. 8-bit data is
+// scaled up to 16 bits.
+func ReadBinary(r io.Reader) (*Peaks, error) {
+	br := bufio.NewReader(r)
+	var h [6]uint32
+	if err := binary.Read(br, binary.LittleEndian, h[:5]); err != nil {
+		return nil, fmt.Errorf("peaks: reading header: %w", err)
+	}
+	p := &Peaks{SampleRate: int(h[2]), SamplesPerPixel: int(h[3]), Channels: 1}
+	switch h[0] {
+	case 1:
+	case 2:
+		if err := binary.Read(br, binary.LittleEndian, &h[5]); err != nil {
+			return nil, fmt.Errorf("peaks: reading header: %w", err)
+		}
+		p.Channels = int(h[5])
+	default:
+		return nil, fmt.Errorf("peaks: unsupported version %d", h[0])
+	}
+	if p.Channels <= 0 || p.SampleRate <= 0 || p.SamplesPerPixel <= 0 {
+		return nil, fmt.Errorf("peaks: invalid header")
+	}
+
+	n := int(h[4]) * 2 * p.Channels
+	p.Data = make([]int16, n)
+	if h[1]&flag8Bit != 0 {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, fmt.Errorf("peaks: reading data: %w", err)
+		}
+		for i, v := range b {
+			p.Data[i] = int16(int8(v)) << 8
+		}
+	} else if err := binary.Read(br, binary.LittleEndian, p.Data); err != nil {
+		return nil, fmt.Errorf("peaks: reading data: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,131 @@
+// Package peaks computes min/max waveform data at multiple resolutions and
+// encodes it in the JSON and binary (.dat) formats of BBC's audiowaveform,
+// which web waveform viewers such as peaks.js read directly.
+package peaks
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// BaseSamplesPerPixel is the finest resolution computed. Coarser ones are
+	// derived from it by merging pixels.
+	BaseSamplesPerPixel = 256
+	// MaxSamplesPerPixel is the coarsest resolution served.
+	MaxSamplesPerPixel = BaseSamplesPerPixel << 8
+)
+
+// Peaks holds the minimum and maximum sample of each channel over
+// consecutive runs of SamplesPerPixel frames, as 16-bit values.
+type Peaks struct {
+	SampleRate      int
+	Channels        int
+	SamplesPerPixel int
+	// Data holds, for each pixel and then each channel, the minimum followed by the maximum.
+	Data []int16
+}
+
+// Length returns the number of pixels.
+func (p *Peaks) Length() int {
+	return len(p.Data) / (2 * p.Channels)
+}
+
+// Resample merges pixels to produce the coarser resolution samplesPerPixel,
+// which must be a multiple of p's.
+func (p *Peaks) Resample(samplesPerPixel int) (*Peaks, error) {
+	if samplesPerPixel <= 0 || samplesPerPixel%p.SamplesPerPixel != 0 {
+		return nil, fmt.Errorf("peaks: cannot resample %d samples per pixel to %d", p.SamplesPerPixel, samplesPerPixel)
+	}
+	factor := samplesPerPixel / p.SamplesPerPixel
+	if factor == 1 {
+		return p, nil
+	}
+
+	stride := 2 * p.Channels
+	length := (p.Length() + factor - 1) / factor
+	out := &Peaks{
+		SampleRate:      p.SampleRate,
+		Channels:        p.Channels,
+		SamplesPerPixel: samplesPerPixel,
+		Data:            make([]int16, length*stride),
+	}
+	for i := range length {
+		dst := out.Data[i*stride : (i+1)*stride]
+		src := p.Data[i*factor*stride : min((i+1)*factor, p.Length())*stride]
+		copy(dst, src[:stride])
+		for j := stride; j < len(src); j += stride {
+			for c := 0; c < stride; c += 2 {
+				dst[c] = min(dst[c], src[j+c])
+				dst[c+1] = max(dst[c+1], src[j+c+1])
+			}
+		}
+	}
+	return out, nil
+}
+
+// Builder computes peaks from interleaved samples as they are produced.
+type Builder struct {
+	peaks  Peaks
+	frames int       // frames in the pixel being filled
+	lo, hi []float32 // per channel extremes of that pixel
+}
+
+// NewBuilder creates a builder at the base resolution.
+func NewBuilder(sampleRate, channels int) *Builder {
+	return &Builder{
+		peaks: Peaks{
+			SampleRate:      sampleRate,
+			Channels:        channels,
+			SamplesPerPixel: BaseSamplesPerPixel,
+		},
+		lo: make([]float32, channels),
+		hi: make([]float32, channels),
+	}
+}
+
+// Write adds interleaved samples in [-1, 1].
+func (b *Builder) Write(samples []float32) {
+	channels := b.peaks.Channels
+	for i := 0; i+channels <= len(samples); i += channels {
+		frame := samples[i : i+channels]
+		if b.frames == 0 {
+			copy(b.lo, frame)
+			copy(b.hi, frame)
+		} else {
+			for c, s := range frame {
+				b.lo[c] = min(b.lo[c], s)
+				b.hi[c] = max(b.hi[c], s)
+			}
+		}
+		b.frames++
+		if b.frames == b.peaks.SamplesPerPixel {
+			b.peaks.Data = b.appendPixel(b.peaks.Data)
+			b.frames = 0
+		}
+	}
+}
+
+func (b *Builder) appendPixel(data []int16) []int16 {
+	for c := range b.lo {
+		data = append(data, quantize(b.lo[c]), quantize(b.hi[c]))
+	}
+	return data
+}
+
+// Peaks returns the peaks of everything written so far, including a final
+// partial pixel. The builder can still be written to afterwards.
+func (b *Builder) Peaks() *Peaks {
+	p := b.peaks
+	p.Data = make([]int16, len(b.peaks.Data), len(b.peaks.Data)+2*p.Channels)
+	copy(p.Data, b.peaks.Data)
+	if b.frames > 0 {
+		p.Data = b.appendPixel(p.Data)
+	}
+	return &p
+}
+
+// quantize converts a sample to 16 bits, clipping at full scale.
+func quantize(s float32) int16 {
+	return int16(math.Round(float64(max(-1, min(1, s)) * math.MaxInt16)))
+}
@@ -0,0 +1,164 @@
+package peaks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// testPeaks returns five stereo pixels of distinct extremes.
+func testPeaks() *Peaks {
+	return &Peaks{
+		SampleRate:      48000,
+		Channels:        2,
+		SamplesPerPixel: BaseSamplesPerPixel,
+		Data: []int16{
+			-100, 200, -10, 20,
+			-300, 100, -5, 50,
+			-50, 400, -40, 10,
+			-32768, 32767, 0, 0,
+			-1, 1, -2, 2,
+		},
+	}
+}
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		name   string
+		factor int
+		want   []int16
+	}{
+		{"unchanged", 1, testPeaks().Data},
+		// Pixels 0+1 and 2+3, then the final pixel on its own.
+		{"partial final pixel", 2, []int16{-300, 200, -10, 50, -32768, 32767, -40, 10, -1, 1, -2, 2}},
+		{"one pixel", 8, []int16{-32768, 32767, -40, 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := testPeaks().Resample(tt.factor * BaseSamplesPerPixel)
+			if err != nil {
+				t.Fatalf("Resample: %v", err)
+			}
+			if p.SamplesPerPixel != tt.factor*BaseSamplesPerPixel || p.Channels != 2 || p.SampleRate != 48000 {
+				t.Fatalf("resampled to %+v", p)
+			}
+			if !slices.Equal(p.Data, tt.want) {
+				t.Fatalf("data = %v, want %v", p.Data, tt.want)
+			}
+		})
+	}
+
+	for _, spp := range []int{0, -BaseSamplesPerPixel, BaseSamplesPerPixel + 1, BaseSamplesPerPixel / 2} {
+		if _, err := testPeaks().Resample(spp); err == nil {
+			t.Errorf("Resample(%d) succeeded, want an error", spp)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder(8000, 1)
+	samples := make([]float32, BaseSamplesPerPixel+10)
+	samples[5], samples[100] = -0.5, 0.25
+	samples[BaseSamplesPerPixel+3] = 2 // clipped
+	b.Write(samples[:50])
+	b.Write(samples[50:]) // pixels straddle writes
+
+	want := []int16{-16384, 8192, 0, 32767}
+	if p := b.Peaks(); !slices.Equal(p.Data, want) {
+		t.Fatalf("data = %v, want %v", p.Data, want)
+	}
+	// The partial pixel stays open for more audio.
+	b.Write(make([]float32, BaseSamplesPerPixel-10))
+	b.Write([]float32{-1})
+	if p := b.Peaks(); !slices.Equal(p.Data, []int16{-16384, 8192, 0, 32767, -32767, -32767}) {
+		t.Fatalf("data after more audio = %v", p.Data)
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	tests := []struct {
+		bits int
+		want []int16
+	}{
+		{16, testPeaks().Data},
+		// 8 bits keep the high byte, scaled back up on reading.
+		{8, []int16{-256, 0, -256, 0, -512, 0, -256, 0, -256, 256, -256, 0, -32768, 32512, 0, 0, -256, 0, -256, 0}},
+	}
+	for _, tt := range tests {
+		p := testPeaks()
+		var buf bytes.Buffer
+		if err := p.WriteBinary(&buf, tt.bits); err != nil {
+			t.Fatalf("WriteBinary(%d): %v", tt.bits, err)
+		}
+		if want := 24 + len(p.Data)*tt.bits/8; buf.Len() != want {
+			t.Fatalf("%d-bit file of %d bytes, want %d", tt.bits, buf.Len(), want)
+		}
+		got, err := ReadBinary(&buf)
+		if err != nil {
+			t.Fatalf("ReadBinary(%d bits): %v", tt.bits, err)
+		}
+		if got.SampleRate != p.SampleRate || got.Channels != p.Channels || got.SamplesPerPixel != p.SamplesPerPixel {
+			t.Fatalf("read header %+v, want %+v", got, p)
+		}
+		if !slices.Equal(got.Data, tt.want) {
+			t.Fatalf("%d-bit data = %v, want %v", tt.bits, got.Data, tt.want)
+		}
+	}
+
+	if err := testPeaks().WriteBinary(&bytes.Buffer{}, 12); err == nil {
+		t.Fatal("WriteBinary with 12 bits succeeded, want an error")
+	}
+}
+
+func TestReadBinaryVersion1(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{1, 0, 44100, 512, 2})
+	binary.Write(&buf, binary.LittleEndian, []int16{-5, 5, -7, 7})
+	p, err := ReadBinary(&buf)
+	if err != nil {
+		t.Fatalf("ReadBinary: %v", err)
+	}
+	if p.Channels != 1 || p.SampleRate != 44100 || p.SamplesPerPixel != 512 || !slices.Equal(p.Data, []int16{-5, 5, -7, 7}) {
+		t.Fatalf("read %+v", p)
+	}
+}
+
+func TestReadBinaryRejectsBadInput(t *testing.T) {
+	var full bytes.Buffer
+	testPeaks().WriteBinary(&full, 16)
+	tests := map[string][]byte{
+		"truncated header": full.Bytes()[:10],
+		"truncated data":   full.Bytes()[:full.Len()-1],
+		"unknown version":  append(binary.LittleEndian.AppendUint32(nil, 3), full.Bytes()[4:]...),
+		"no channels":      append(append(bytes.Clone(full.Bytes()[:20]), 0, 0, 0, 0), full.Bytes()[24:]...),
+	}
+	for name, data := range tests {
+		if _, err := ReadBinary(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: ReadBinary succeeded, want an error", name)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	p := testPeaks()
+	var buf bytes.Buffer
+	if err := p.WriteJSON(&buf, 16); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var got jsonPeaks
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decoding JSON: %v", err)
+	}
+	want := jsonPeaks{Version: 2, Channels: 2, SampleRate: 48000, SamplesPerPixel: BaseSamplesPerPixel, Bits: 16, Length: 5}
+	if data := got.Data; got.Version != want.Version || got.Channels != want.Channels || got.SampleRate != want.SampleRate ||
+		got.SamplesPerPixel != want.SamplesPerPixel || got.Bits != want.Bits || got.Length != want.Length || !slices.Equal(data, p.Data) {
+		t.Fatalf("JSON = %+v, want %+v with the data", got, want)
+	}
+	for _, key := range []string{`"sample_rate"`, `"samples_per_pixel"`} {
+		if !bytes.Contains(buf.Bytes(), []byte(key)) {
+			t.Fatalf("JSON lacks the audiowaveform key %s", key)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package wav
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// bextSize is the length of the fixed part of a version 2 bext chunk.
+const bextSize = 602
+
+// Bext is the Broadcast Wave Format extension chunk (EBU Tech 3285). DAWs
+// use its origination time and TimeReference to place a file on the timeline
+// where it was recorded.
+type Bext struct {
+	Description         string // up to 256 characters
+	Originator          string // up to 32 characters
+	OriginatorReference string // up to 32 characters
+	Origination         time.Time
+	TimeReference       uint64        // position of the first sample, in samples since midnight
+	Loudness            *BextLoudness // nil if not measured
+	CodingHistory       string
+}
+
+// BextLoudness holds the EBU R128 measurements stored in a bext chunk.
+type BextLoudness struct {
+	Integrated float64 // LUFS
+	Range      float64 // LU
+	TruePeak   float64 // dBTP
+}
+
+// TimeReferenceAt returns the number of samples between local midnight and t.
+func TimeReferenceAt(t time.Time, sampleRate int) uint64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return uint64(t.Sub(midnight).Seconds() * float64(sampleRate))
+}
+
+// Encode returns the body of the bext chunk. Strings are truncated to their
+// field lengths, and loudness fields without a value are marked as not present.
+func (b Bext) Encode() []byte {
+	le := binary.LittleEndian
+	buf := make([]byte, bextSize, bextSize+len(b.CodingHistory))
+	copy(buf[0:256], b.Description)
+	copy(buf[256:288], b.Originator)
+	copy(buf[288:320], b.OriginatorReference)
+	if !b.Origination.IsZero() {
+		copy(buf[320:330], b.Origination.Format("2006-01-02"))
+		copy(buf[330:338], b.Origination.Format("15:04:05"))
+	}
+	le.PutUint32(buf[338:342], uint32(b.TimeReference))
+	le.PutUint32(buf[342:346], uint32(b.TimeReference>>32))
+	le.PutUint16(buf[346:348], 2) // version
+	// UMID (64 bytes at 348) is left empty.
+	for o := 412; o < 422; o += 2 {
+		le.PutUint16(buf[o:], 0x7FFF) // loudness value not present
+	}
+	if l := b.Loudness; l != nil {
+		// Stored in hundredths; the momentary and short-term maxima stay unset.
+		le.PutUint16(buf[412:], uint16(bextLevel(l.Integrated)))
+		le.PutUint16(buf[414:], uint16(bextLevel(l.Range)))
+		le.PutUint16(buf[416:], uint16(bextLevel(l.TruePeak)))
+	}
+	// 180 reserved bytes follow, then the coding history.
+	return append(buf, b.CodingHistory...)
+}
+
+// bextLevel converts a level to the hundredths stored in a bext chunk,
+// keeping clear of the 0x7FFF that marks a missing value.
+func bextLevel(v float64) int16 {
+	return int16(math.Round(min(max(v*100, math.MinInt16), math.MaxInt16-1)))
+}
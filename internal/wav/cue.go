@@ -0,0 +1,86 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Cue is a named position in a WAV file, stored in the cue chunk with its
+// label in a LIST/adtl chunk so DAWs show it as a marker. A cue with a
+// Length also gets an ltxt entry, which marks it as a region.
+type Cue struct {
+	Frame  int64
+	Length int64 // region length in frames, 0 for a point marker
+	Label  string
+}
+
+// AddCue records a cue point to be written when the file is closed.
+func (w *Writer) AddCue(frame int64, label string) {
+	w.cues = append(w.cues, Cue{Frame: frame, Label: label})
+}
+
+// AddRegion records a region of length frames starting at frame, to be
+// written when the file is closed.
+func (w *Writer) AddRegion(frame, length int64, label string) {
+	w.cues = append(w.cues, Cue{Frame: frame, Length: length, Label: label})
+}
+
+// CueChunks encodes cues as the cue and LIST/adtl chunks, for ReplaceChunks.
+// Without cues the chunks have no data, which removes them from a file.
+func CueChunks(cues []Cue) []Chunk {
+	if len(cues) == 0 {
+		return []Chunk{{ID: "cue "}, {ID: "LIST"}}
+	}
+	le := binary.LittleEndian
+
+	cue := make([]byte, 4+24*len(cues))
+	le.PutUint32(cue[0:4], uint32(len(cues)))
+	for i, c := range cues {
+		p := cue[4+24*i:]
+		le.PutUint32(p[0:4], uint32(i+1))     // cue point ID
+		le.PutUint32(p[4:8], uint32(c.Frame)) // play order position
+		copy(p[8:12], "data")
+		le.PutUint32(p[12:16], 0) // chunk start
+		le.PutUint32(p[16:20], 0) // block start
+		le.PutUint32(p[20:24], uint32(c.Frame))
+	}
+
+	var adtl bytes.Buffer
+	adtl.WriteString("adtl")
+	for i, c := range cues {
+		if c.Label != "" {
+			labl := make([]byte, 4, 4+len(c.Label)+1)
+			le.PutUint32(labl, uint32(i+1))
+			labl = append(labl, c.Label...)
+			labl = append(labl, 0)
+			writeChunk(&adtl, "labl", labl)
+		}
+		if c.Length > 0 {
+			ltxt := make([]byte, 20) // country, language, dialect and code page left at 0
+			le.PutUint32(ltxt[0:4], uint32(i+1))
+			le.PutUint32(ltxt[4:8], uint32(c.Length))
+			copy(ltxt[8:12], "rgn ")
+			writeChunk(&adtl, "ltxt", ltxt)
+		}
+	}
+	return []Chunk{{ID: "cue ", Data: cue}, {ID: "LIST", Data: adtl.Bytes()}}
+}
+
+// appendChunk appends the encoding of c to b.
+func appendChunk(b []byte, c Chunk) []byte {
+	buf := bytes.NewBuffer(b)
+	writeChunk(buf, c.ID, c.Data)
+	return buf.Bytes()
+}
+
+// writeChunk appends a RIFF chunk with its word-alignment pad byte.
+func writeChunk(b *bytes.Buffer, id string, body []byte) {
+	var h [8]byte
+	copy(h[0:4], id)
+	binary.LittleEndian.PutUint32(h[4:8], uint32(len(body)))
+	b.Write(h[:])
+	b.Write(body)
+	if len(body)%2 == 1 {
+		b.WriteByte(0)
+	}
+}
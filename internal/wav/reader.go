@@ -0,0 +1,177 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Info describes the format and length of a WAV file.
+type Info struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	Float         bool // IEEE float samples rather than integer PCM
+	Frames        int64
+	Duration      time.Duration
+	Size          int64
+	// DataOffset is the file offset of the first PCM byte.
+	DataOffset int64
+}
+
+// Stat reads the header of the WAV file at path.
+func Stat(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	return readInfo(f)
+}
+
+// readInfo walks the RIFF chunks of f up to the data chunk.
+func readInfo(f *os.File) (Info, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+
+	var riff [12]byte
+	if _, err := io.ReadFull(f, riff[:]); err != nil {
+		return Info{}, fmt.Errorf("wav: reading RIFF header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return Info{}, fmt.Errorf("wav: not a RIFF/WAVE file")
+	}
+
+	info := Info{Size: st.Size()}
+	offset := int64(12)
+	var blockAlign int64
+	for {
+		var ch [8]byte
+		if _, err := f.ReadAt(ch[:], offset); err != nil {
+			return Info{}, fmt.Errorf("wav: no data chunk found")
+		}
+		id := string(ch[0:4])
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		body := offset + 8
+
+		switch id {
+		case "fmt ":
+			var fmtChunk [16]byte
+			if _, err := f.ReadAt(fmtChunk[:], body); err != nil {
+				return Info{}, fmt.Errorf("wav: reading fmt chunk: %w", err)
+			}
+			format := binary.LittleEndian.Uint16(fmtChunk[0:2])
+			switch format {
+			case 1, 0xFFFE: // PCM or WAVE_FORMAT_EXTENSIBLE
+			case 3: // IEEE float
+				info.Float = true
+			default:
+				return Info{}, fmt.Errorf("wav: unsupported format tag %#x", format)
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			blockAlign = int64(binary.LittleEndian.Uint16(fmtChunk[12:14]))
+			info.BitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+		case "data":
+			if blockAlign == 0 || info.SampleRate == 0 {
+				return Info{}, fmt.Errorf("wav: data chunk before fmt chunk")
+			}
+			// Trust the file length over a stale header on a file still being written.
+			if avail := info.Size - body; size > avail {
+				size = avail
+			}
+			info.DataOffset = body
+			info.Frames = size / blockAlign
+			info.Duration = time.Duration(info.Frames) * time.Second / time.Duration(info.SampleRate)
+			return info, nil
+		}
+		offset = body + size + size%2 // chunks are word aligned
+	}
+}
+
+// Reader decodes the PCM frames of a WAV file.
+type Reader struct {
+	f    *os.File
+	info Info
+	pos  int64 // frames read so far
+	buf  []byte
+}
+
+// Open opens the WAV file at path for reading.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := readInfo(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	switch {
+	case info.Float && info.BitsPerSample == 32:
+	case !info.Float && (info.BitsPerSample == 8 || info.BitsPerSample == 16 || info.BitsPerSample == 24 || info.BitsPerSample == 32):
+	default:
+		f.Close()
+		return nil, fmt.Errorf("wav: unsupported sample format (%d-bit, float=%t)", info.BitsPerSample, info.Float)
+	}
+	return &Reader{f: f, info: info}, nil
+}
+
+// Info returns the format of the file.
+func (r *Reader) Info() Info { return r.info }
+
+// ReadFrames decodes up to len(buf)/Channels frames into buf as floats in
+// [-1, 1] and returns the number of frames read, or io.EOF at the end of the data.
+func (r *Reader) ReadFrames(buf []float32) (int, error) {
+	remaining := r.info.Frames - r.pos
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	frames := int64(len(buf) / r.info.Channels)
+	if frames > remaining {
+		frames = remaining
+	}
+
+	bytesPerSample := r.info.BitsPerSample / 8
+	frameBytes := int64(r.info.Channels * bytesPerSample)
+	n := frames * frameBytes
+	if int64(cap(r.buf)) < n {
+		r.buf = make([]byte, n)
+	}
+	raw := r.buf[:n]
+	if _, err := r.f.ReadAt(raw, r.info.DataOffset+r.pos*frameBytes); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	samples := int(frames) * r.info.Channels
+	for i := 0; i < samples; i++ {
+		b := raw[i*bytesPerSample:]
+		switch {
+		case r.info.Float:
+			buf[i] = math.Float32frombits(binary.LittleEndian.Uint32(b))
+		case bytesPerSample == 1:
+			buf[i] = (float32(b[0]) - 128) / 128
+		case bytesPerSample == 2:
+			buf[i] = float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+		case bytesPerSample == 3:
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			buf[i] = float32(v) / (1 << 23)
+		case bytesPerSample == 4:
+			buf[i] = float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+		}
+	}
+	r.pos += frames
+	return int(frames), nil
+}
+
+// Rewind moves the read position back to the first frame.
+func (r *Reader) Rewind() { r.pos = 0 }
+
+// Close closes the underlying file.
+func (r *Reader) Close() error { return r.f.Close() }
@@ -0,0 +1,65 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Snapshot is a read-only view of the audio written so far to a file that is
+// still being recorded. Its header is patched to describe exactly the data in
+// the view, so it is a complete WAV file even though the writer only updates
+// the header on disk at each Sync.
+type Snapshot struct {
+	*io.SectionReader
+	f *os.File
+}
+
+// OpenSnapshot opens path and exposes its first dataSize bytes of audio.
+// dataSize must not exceed what has already been written.
+func OpenSnapshot(path string, dataSize int64) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, h); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("wav: reading header: %w", err)
+	}
+	if string(h[0:4]) != "RIFF" || string(h[36:40]) != "data" {
+		f.Close()
+		return nil, fmt.Errorf("wav: %s is not a file written by this package", path)
+	}
+	binary.LittleEndian.PutUint32(h[4:8], uint32(headerSize-8+dataSize))
+	binary.LittleEndian.PutUint32(h[40:44], uint32(dataSize))
+
+	r := &snapshotReader{header: h, f: f}
+	return &Snapshot{SectionReader: io.NewSectionReader(r, 0, headerSize+dataSize), f: f}, nil
+}
+
+// Close closes the underlying file.
+func (s *Snapshot) Close() error {
+	return s.f.Close()
+}
+
+// snapshotReader serves the patched header from memory and everything after
+// it from the file.
+type snapshotReader struct {
+	header []byte
+	f      *os.File
+}
+
+func (r *snapshotReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	if off < headerSize {
+		n = copy(p, r.header[off:])
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	m, err := r.f.ReadAt(p[n:], off+int64(n))
+	return n + m, err
+}
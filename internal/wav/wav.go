@@ -0,0 +1,433 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	headerSize     = 44 // RIFF header + fmt chunk + data chunk header
+	riffSizeOffset = 4
+	dataSizeOffset = 40
+	// maxDataSize leaves room under the 32-bit RIFF size for metadata chunks
+	// appended after the audio.
+	maxDataSize = math.MaxUint32 - headerSize - 1<<20
+)
+
+// ErrFileTooLarge is returned when a write would overflow the 32-bit RIFF size fields.
+var ErrFileTooLarge = errors.New("wav: file exceeds the 4 GiB RIFF limit")
+
+// Writer streams interleaved PCM samples to a RIFF/WAVE file.
+//
+// The header is written up front with the sizes known at that time and is
+// rewritten on every Sync, so a file interrupted by a crash or power loss is
+// still playable up to the last sync point. Repair can recover the remainder.
+type Writer struct {
+	f             *os.File
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	dataSize      int64
+	trailerSize   int64 // bytes of metadata chunks written after the data on Close
+	cues          []Cue
+	chunks        []Chunk // other metadata chunks, written after the cues
+	buf           []byte
+}
+
+// Chunk is a RIFF chunk carried after the audio data, such as bext or iXML.
+type Chunk struct {
+	ID   string
+	Data []byte
+}
+
+// SetChunk sets a metadata chunk to be written when the file is closed,
+// replacing any earlier chunk with the same ID.
+func (w *Writer) SetChunk(id string, data []byte) {
+	for i := range w.chunks {
+		if w.chunks[i].ID == id {
+			w.chunks[i].Data = data
+			return
+		}
+	}
+	w.chunks = append(w.chunks, Chunk{ID: id, Data: data})
+}
+
+// Create creates the file at path and writes a provisional WAV header.
+// Supported bit depths are 16 and 24.
+func Create(path string, sampleRate, channels, bitsPerSample int) (*Writer, error) {
+	if bitsPerSample != 16 && bitsPerSample != 24 {
+		return nil, fmt.Errorf("wav: unsupported bit depth %d", bitsPerSample)
+	}
+	if sampleRate <= 0 || channels <= 0 {
+		return nil, fmt.Errorf("wav: invalid format %d Hz / %d ch", sampleRate, channels)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		f:             f,
+		sampleRate:    sampleRate,
+		channels:      channels,
+		bitsPerSample: bitsPerSample,
+	}
+	if _, err := f.Write(w.header()); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return w, nil
+}
+
+// header builds the canonical 44-byte PCM header for the current data size.
+func (w *Writer) header() []byte {
+	blockAlign := w.channels * w.bitsPerSample / 8
+	h := make([]byte, headerSize)
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], uint32(headerSize-8+w.dataSize))
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], 1) // WAVE_FORMAT_PCM
+	binary.LittleEndian.PutUint16(h[22:24], uint16(w.channels))
+	binary.LittleEndian.PutUint32(h[24:28], uint32(w.sampleRate))
+	binary.LittleEndian.PutUint32(h[28:32], uint32(w.sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(h[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(h[34:36], uint16(w.bitsPerSample))
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], uint32(w.dataSize))
+	return h
+}
+
+// WriteFrames converts interleaved float samples in [-1, 1] to PCM and appends them.
+// len(samples) must be a multiple of the channel count.
+func (w *Writer) WriteFrames(samples []float32) error {
+	if len(samples)%w.channels != 0 {
+		return fmt.Errorf("wav: %d samples is not a multiple of %d channels", len(samples), w.channels)
+	}
+	bytesPerSample := w.bitsPerSample / 8
+	n := len(samples) * bytesPerSample
+	if w.dataSize+int64(n) > maxDataSize {
+		return ErrFileTooLarge
+	}
+
+	if cap(w.buf) < n {
+		w.buf = make([]byte, n)
+	}
+	buf := w.buf[:n]
+	for i, s := range samples {
+		v := quantize(s, w.bitsPerSample)
+		o := i * bytesPerSample
+		switch bytesPerSample {
+		case 2:
+			binary.LittleEndian.PutUint16(buf[o:], uint16(int16(v)))
+		case 3:
+			buf[o] = byte(v)
+			buf[o+1] = byte(v >> 8)
+			buf[o+2] = byte(v >> 16)
+		}
+	}
+
+	written, err := w.f.Write(buf)
+	w.dataSize += int64(written)
+	return err
+}
+
+// quantize scales a float sample to a signed integer of the given bit depth, clipping at full scale.
+func quantize(s float32, bits int) int32 {
+	max := float32(int32(1)<<(bits-1) - 1)
+	if s > 1 {
+		s = 1
+	} else if s < -1 {
+		s = -1
+	}
+	return int32(math.Round(float64(s * max)))
+}
+
+// Sync rewrites the header sizes to match the data written so far and flushes to disk.
+func (w *Writer) Sync() error {
+	if err := w.updateSizes(); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *Writer) updateSizes() error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(w.Size()-8))
+	if _, err := w.f.WriteAt(b[:], riffSizeOffset); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b[:], uint32(w.dataSize))
+	_, err := w.f.WriteAt(b[:], dataSizeOffset)
+	return err
+}
+
+// Close appends any metadata chunks, finalizes the header and closes the file.
+// The header is synced for the audio alone before the chunks are written, so
+// if that is interrupted Repair knows where the audio ends.
+func (w *Writer) Close() error {
+	err := w.Sync()
+	if err == nil {
+		err = w.writeTrailer()
+	}
+	if err == nil && w.trailerSize > 0 {
+		err = w.Sync()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeTrailer appends the metadata chunks that follow the audio data.
+func (w *Writer) writeTrailer() error {
+	var trailer []byte
+	if len(w.cues) > 0 {
+		for _, c := range CueChunks(w.cues) {
+			trailer = appendChunk(trailer, c)
+		}
+	}
+	for _, c := range w.chunks {
+		trailer = appendChunk(trailer, c)
+	}
+	if len(trailer) == 0 {
+		return nil
+	}
+	if w.dataSize%2 == 1 { // the data chunk must be padded to an even length
+		trailer = append([]byte{0}, trailer...)
+	}
+	if _, err := w.f.WriteAt(trailer, headerSize+w.dataSize); err != nil {
+		return err
+	}
+	w.trailerSize = int64(len(trailer))
+	return nil
+}
+
+// MaxFrames returns the largest number of frames a file with the given format can hold.
+func MaxFrames(channels, bitsPerSample int) int64 {
+	return maxDataSize / int64(channels*bitsPerSample/8)
+}
+
+// Frames returns the number of sample frames written.
+func (w *Writer) Frames() int64 {
+	return w.dataSize / int64(w.channels*w.bitsPerSample/8)
+}
+
+// DataSize returns the number of audio bytes written.
+func (w *Writer) DataSize() int64 {
+	return w.dataSize
+}
+
+// Size returns the current size of the file in bytes.
+func (w *Writer) Size() int64 {
+	return headerSize + w.dataSize + w.trailerSize
+}
+
+// Repair fixes the RIFF and data chunk sizes of a WAV file whose header was
+// not finalized, e.g. after a crash. It reports whether the file was modified.
+//
+// The header holds the sizes of the last sync. If metadata chunks follow the
+// audio it describes, the file was being closed: the audio ends there and the
+// chunks written in full are kept. Otherwise everything after the header is
+// audio, including whatever was written after the last sync.
+func Repair(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	fileSize := info.Size()
+
+	h := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, h); err != nil {
+		return false, fmt.Errorf("wav: reading header: %w", err)
+	}
+	if string(h[0:4]) != "RIFF" || string(h[8:12]) != "WAVE" || string(h[36:40]) != "data" {
+		return false, fmt.Errorf("wav: %s is not a file written by this package", path)
+	}
+	blockAlign := int64(binary.LittleEndian.Uint16(h[32:34]))
+	if blockAlign == 0 {
+		return false, fmt.Errorf("wav: invalid block alignment in %s", path)
+	}
+
+	synced := int64(binary.LittleEndian.Uint32(h[40:44]))
+	if synced%blockAlign == 0 && finalized(f, h, fileSize) {
+		return false, nil
+	}
+
+	dataSize := fileSize - headerSize
+	end := int64(-1)
+	if synced%blockAlign == 0 && synced <= dataSize {
+		if trailerEnd, ok := readTrailer(f, headerSize+synced+synced%2, fileSize); ok {
+			dataSize, end = synced, trailerEnd
+		}
+	}
+	if end < 0 {
+		// Drop any partially written trailing frame so the file stays aligned.
+		dataSize -= dataSize % blockAlign
+		if dataSize > maxDataSize {
+			dataSize = maxDataSize - maxDataSize%blockAlign
+		}
+		end = headerSize + dataSize
+	}
+
+	if err := f.Truncate(end); err != nil {
+		return false, err
+	}
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(end-8))
+	if _, err := f.WriteAt(b[:], riffSizeOffset); err != nil {
+		return false, err
+	}
+	binary.LittleEndian.PutUint32(b[:], uint32(dataSize))
+	if _, err := f.WriteAt(b[:], dataSizeOffset); err != nil {
+		return false, err
+	}
+	return true, f.Sync()
+}
+
+// trailerIDs are the IDs of the chunks Close writes after the audio.
+var trailerIDs = map[string]bool{"cue ": true, "LIST": true, "bext": true, "iXML": true}
+
+// readTrailer reports whether the metadata chunks written on Close start at
+// offset and returns the end of the last of them that is complete.
+func readTrailer(f *os.File, offset, fileSize int64) (int64, bool) {
+	var ch [8]byte
+	if offset+8 > fileSize {
+		return 0, false
+	}
+	if _, err := f.ReadAt(ch[:], offset); err != nil || !trailerIDs[string(ch[0:4])] {
+		return 0, false
+	}
+	for offset+8 <= fileSize {
+		if _, err := f.ReadAt(ch[:], offset); err != nil {
+			break
+		}
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		next := offset + 8 + size + size%2
+		if next > fileSize {
+			break
+		}
+		offset = next
+	}
+	return offset, true
+}
+
+// ReplaceChunks rewrites the metadata chunks after the audio of a file written
+// by this package: chunks with the IDs of those given are replaced, others are
+// kept, and new ones are appended. A given chunk without data only removes
+// those with its ID. The audio itself is never touched. The old
+// chunks are dropped before the new ones are written and synced, and only then
+// does the RIFF size cover them, so a rewrite interrupted by a crash leaves
+// the audio intact with the chunks written in full. The next rewrite, or
+// Repair, settles the sizes again.
+func ReplaceChunks(path string, chunks ...Chunk) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+	h := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, h); err != nil {
+		return fmt.Errorf("wav: reading header: %w", err)
+	}
+	if string(h[0:4]) != "RIFF" || string(h[8:12]) != "WAVE" || string(h[36:40]) != "data" {
+		return fmt.Errorf("wav: %s is not a file written by this package", path)
+	}
+	dataSize := int64(binary.LittleEndian.Uint32(h[40:44]))
+	start := headerSize + dataSize + dataSize%2
+	end := fileSize
+	if !finalized(f, h, fileSize) && start < fileSize {
+		var ok bool
+		if end, ok = readTrailer(f, start, fileSize); !ok {
+			return fmt.Errorf("wav: %s is not a finalized file written by this package", path)
+		}
+	}
+
+	replaced := make(map[string]bool, len(chunks))
+	for _, c := range chunks {
+		replaced[c.ID] = true
+	}
+	var trailer []byte
+	for offset := start; offset < end; {
+		var ch [8]byte
+		if _, err := f.ReadAt(ch[:], offset); err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		if !replaced[string(ch[0:4])] {
+			body := make([]byte, size)
+			if _, err := f.ReadAt(body, offset+8); err != nil {
+				return err
+			}
+			trailer = appendChunk(trailer, Chunk{ID: string(ch[0:4]), Data: body})
+		}
+		offset += 8 + size + size%2
+	}
+	for _, c := range chunks {
+		if c.Data != nil {
+			trailer = appendChunk(trailer, c)
+		}
+	}
+
+	if dataSize%2 == 1 {
+		start-- // rewrite the pad byte too, in case it was never written
+		trailer = append([]byte{0}, trailer...)
+	}
+	if err := f.Truncate(start); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(trailer, start); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(start+int64(len(trailer))-8))
+	if _, err := f.WriteAt(b[:], riffSizeOffset); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// finalized reports whether a file with metadata chunks after its audio is
+// internally consistent: the header sizes cover the whole file and the
+// chunks after the data end exactly at the end of the file.
+func finalized(f *os.File, h []byte, fileSize int64) bool {
+	if int64(binary.LittleEndian.Uint32(h[4:8])) != fileSize-8 {
+		return false
+	}
+	dataSize := int64(binary.LittleEndian.Uint32(h[40:44]))
+	if headerSize+dataSize == fileSize {
+		return true // no trailing chunks, and no pad byte needed after the data
+	}
+	offset := headerSize + dataSize + dataSize%2
+	for offset < fileSize {
+		var ch [8]byte
+		if _, err := f.ReadAt(ch[:], offset); err != nil {
+			return false
+		}
+		size := int64(binary.LittleEndian.Uint32(ch[4:8]))
+		offset += 8 + size + size%2
+	}
+	return offset == fileSize
+}
@@ -0,0 +1,302 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testSignal returns frames of a sine sweep across channels, distinct enough
+// per sample that misplaced or misread bytes show up.
+func testSignal(frames, channels int) []float32 {
+	s := make([]float32, frames*channels)
+	for i := range s {
+		s[i] = float32(0.8 * math.Sin(float64(i)*0.01*float64(1+i%channels)))
+	}
+	return s
+}
+
+// readAll decodes every frame of the WAV file at path.
+func readAll(t *testing.T, path string) (Info, []float32) {
+	t.Helper()
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	info := r.Info()
+	out := make([]float32, 0, info.Frames*int64(info.Channels))
+	buf := make([]float32, 1000*info.Channels)
+	for {
+		n, err := r.ReadFrames(buf)
+		if err != nil {
+			break
+		}
+		out = append(out, buf[:n*info.Channels]...)
+	}
+	return info, out
+}
+
+// checkSamples compares decoded samples with the input they were written
+// from, allowing for quantization.
+func checkSamples(t *testing.T, got, want []float32, bits int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("decoded %d samples, want %d", len(got), len(want))
+	}
+	tolerance := float32(1.5) / float32(int32(1)<<(bits-1))
+	for i := range got {
+		if d := got[i] - want[i]; d > tolerance || d < -tolerance {
+			t.Fatalf("sample %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// chunkIDs lists the IDs of the chunks that follow the audio of a file.
+func chunkIDs(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(binary.LittleEndian.Uint32(data[40:44]))
+	var ids []string
+	for offset := headerSize + size + size%2; offset+8 <= int64(len(data)); {
+		ids = append(ids, string(data[offset:offset+4]))
+		n := int64(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		offset += 8 + n + n%2
+	}
+	return ids
+}
+
+func TestRepairInterruptedClose(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		bits     int
+		frames   int
+		cut      int // bytes missing from the end of the trailer
+		wantIDs  []string
+	}{
+		{"complete trailer", 2, 16, 4800, 0, []string{"cue ", "LIST", "bext"}},
+		{"cut in last chunk", 2, 24, 4800, 10, []string{"cue ", "LIST"}},
+		{"cut in first chunk", 1, 16, 4800, 300, nil},
+		{"odd data size", 1, 24, 4801, 0, []string{"cue ", "LIST", "bext"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "take.wav")
+			w, err := Create(path, 48000, tt.channels, tt.bits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples := testSignal(tt.frames, tt.channels)
+			if err := w.WriteFrames(samples); err != nil {
+				t.Fatal(err)
+			}
+			w.AddCue(100, "first")
+			w.AddRegion(200, 300, "region")
+			w.SetChunk("bext", make([]byte, 200))
+
+			// Close up to the point where the trailer is on disk but the
+			// header does not cover it yet.
+			if err := w.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.writeTrailer(); err != nil {
+				t.Fatal(err)
+			}
+			w.f.Close()
+			if tt.cut > 0 {
+				st, _ := os.Stat(path)
+				os.Truncate(path, st.Size()-int64(tt.cut))
+			}
+
+			repaired, err := Repair(path)
+			if err != nil {
+				t.Fatalf("Repair: %v", err)
+			}
+			if !repaired {
+				t.Fatal("Repair reported nothing to do")
+			}
+			info, got := readAll(t, path)
+			if info.Frames != int64(tt.frames) {
+				t.Fatalf("repaired file has %d frames, want %d", info.Frames, tt.frames)
+			}
+			checkSamples(t, got, samples, tt.bits)
+			if ids := chunkIDs(t, path); !slices.Equal(ids, tt.wantIDs) {
+				t.Fatalf("chunks after repair = %q, want %q", ids, tt.wantIDs)
+			}
+
+			if again, err := Repair(path); err != nil || again {
+				t.Fatalf("second Repair = %t, %v; want no change", again, err)
+			}
+		})
+	}
+}
+
+func TestCloseWritesTrailerAfterAudio(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "take.wav")
+	w, err := Create(path, 48000, 2, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := testSignal(1000, 2)
+	if err := w.WriteFrames(samples); err != nil {
+		t.Fatal(err)
+	}
+	w.AddCue(10, "marker")
+	w.SetChunk("iXML", []byte("<BWFXML/>"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(data[4:8]); int(got) != len(data)-8 {
+		t.Fatalf("RIFF size = %d, want %d", got, len(data)-8)
+	}
+	if !bytes.Contains(data, []byte("<BWFXML/>")) {
+		t.Fatal("iXML chunk missing")
+	}
+	if repaired, err := Repair(path); err != nil || repaired {
+		t.Fatalf("Repair of a closed file = %t, %v; want no change", repaired, err)
+	}
+	_, got := readAll(t, path)
+	checkSamples(t, got, samples, 16)
+}
+
+func TestReplaceChunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "take.wav")
+	w, err := Create(path, 48000, 1, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := testSignal(4801, 1)
+	if err := w.WriteFrames(samples); err != nil {
+		t.Fatal(err)
+	}
+	w.AddCue(10, "marker")
+	w.SetChunk("bext", make([]byte, 602))
+	w.SetChunk("iXML", []byte("<old/>"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReplaceChunks(path, Chunk{ID: "iXML", Data: []byte("<new/>")}); err != nil {
+		t.Fatalf("ReplaceChunks: %v", err)
+	}
+	if ids, want := chunkIDs(t, path), []string{"cue ", "LIST", "bext", "iXML"}; !slices.Equal(ids, want) {
+		t.Fatalf("chunks = %q, want %q", ids, want)
+	}
+
+	// Interrupt a rewrite after the old chunks were dropped and part of the
+	// new ones written, before the RIFF size was patched.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := headerSize + int64(len(samples)*3) + 1
+	if err := os.WriteFile(path, append(data[:start:start], data[start:start+100]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReplaceChunks(path, Chunk{ID: "iXML", Data: []byte("<newer/>")}); err != nil {
+		t.Fatalf("ReplaceChunks after an interrupted rewrite: %v", err)
+	}
+	if ids, want := chunkIDs(t, path), []string{"cue ", "LIST", "iXML"}; !slices.Equal(ids, want) {
+		t.Fatalf("chunks = %q, want %q", ids, want)
+	}
+	if repaired, err := Repair(path); err != nil || repaired {
+		t.Fatalf("Repair after rewrite = %t, %v; want no change", repaired, err)
+	}
+	_, got := readAll(t, path)
+	checkSamples(t, got, samples, 24)
+}
+
+func TestRepairAfterCrash(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		bits     int
+		partial  int // bytes of an incomplete frame left at the end
+	}{
+		{"whole frames", 2, 16, 0},
+		{"partial frame", 2, 16, 3},
+		{"odd partial frame", 2, 24, 5},
+		{"odd data size", 1, 24, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "take.wav")
+			w, err := Create(path, 48000, tt.channels, tt.bits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples := testSignal(4801, tt.channels)
+			synced := 3001
+			if err := w.WriteFrames(samples[:synced*tt.channels]); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteFrames(samples[synced*tt.channels:]); err != nil {
+				t.Fatal(err)
+			}
+			// Crash without Close, part way through writing another frame.
+			if _, err := w.f.Write(make([]byte, tt.partial)); err != nil {
+				t.Fatal(err)
+			}
+			w.f.Close()
+
+			info, err := Stat(path)
+			if err != nil {
+				t.Fatalf("Stat before repair: %v", err)
+			}
+			if info.Frames != int64(synced) {
+				t.Fatalf("before repair Stat reports %d frames, want the %d synced", info.Frames, synced)
+			}
+
+			repaired, err := Repair(path)
+			if err != nil {
+				t.Fatalf("Repair: %v", err)
+			}
+			if !repaired {
+				t.Fatal("Repair reported nothing to do")
+			}
+			if info, err = Stat(path); err != nil {
+				t.Fatalf("Stat: %v", err)
+			}
+			if info.Frames != 4801 {
+				t.Fatalf("after repair Stat reports %d frames, want 4801", info.Frames)
+			}
+			blockAlign := int64(tt.channels * tt.bits / 8)
+			if want := headerSize + 4801*blockAlign; info.Size != want {
+				t.Fatalf("repaired file is %d bytes, want %d without the partial frame", info.Size, want)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := binary.LittleEndian.Uint32(data[4:8]); int(got) != len(data)-8 {
+				t.Fatalf("RIFF size = %d, want %d", got, len(data)-8)
+			}
+			openInfo, got := readAll(t, path)
+			if openInfo.Frames != 4801 {
+				t.Fatalf("Open reports %d frames, want 4801", openInfo.Frames)
+			}
+			checkSamples(t, got, samples, tt.bits)
+
+			if again, err := Repair(path); err != nil || again {
+				t.Fatalf("second Repair = %t, %v; want no change", again, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// LogEntry is the payload of a log_entry message.
+type LogEntry struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"msg"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// logHandler passes records on to another handler and publishes them to the
+// clients subscribed to logs.
+type logHandler struct {
+	next  slog.Handler
+	attrs []slog.Attr
+	group string // prefix of the keys of attributes added from now on
+}
+
+// NewLogHandler wraps next so that every record it handles is also sent to
+// WebSocket clients subscribed to TopicLogs.
+func NewLogHandler(next slog.Handler) slog.Handler {
+	return &logHandler{next: next}
+}
+
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	err := h.next.Handle(ctx, r)
+	if !HasSubscribers(TopicLogs) {
+		return err
+	}
+
+	entry := LogEntry{Time: r.Time, Level: r.Level.String(), Message: r.Message}
+	if len(h.attrs) > 0 || r.NumAttrs() > 0 {
+		entry.Attrs = make(map[string]any, len(h.attrs)+r.NumAttrs())
+	}
+	for _, a := range h.attrs {
+		addAttr(entry.Attrs, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(entry.Attrs, h.group, a)
+		return true
+	})
+	Publish(TopicLogs, "log_entry", entry)
+	return err
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefixed := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		prefixed[i] = slog.Attr{Key: h.group + a.Key, Value: a.Value}
+	}
+	return &logHandler{
+		next:  h.next.WithAttrs(attrs),
+		attrs: append(append([]slog.Attr(nil), h.attrs...), prefixed...),
+		group: h.group,
+	}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &logHandler{next: h.next.WithGroup(name), attrs: h.attrs, group: h.group + name + "."}
+}
+
+// addAttr adds a to m under its dotted key. Errors and other values without a
+// useful JSON form are converted to text.
+func addAttr(m map[string]any, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			addAttr(m, prefix, ga)
+		}
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			m[prefix+a.Key] = err.Error()
+		} else {
+			m[prefix+a.Key] = v.String()
+		}
+	default:
+		m[prefix+a.Key] = v.Any()
+	}
+}
@@ -2,93 +2,471 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"net/http"
 	"nixon/internal/common"
+	"nixon/internal/config"
 	"nixon/internal/slogger"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
+// sendQueueSize is how many messages may wait for a client's writer. A client
+// that falls this far behind is disconnected rather than slowing anyone else.
+const sendQueueSize = 256
+
+// commandQueueSize is how many commands from a client may wait while an
+// earlier one runs. A client sending more than that is disconnected.
+const commandQueueSize = 64
+
 var (
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 		CheckOrigin:     func(r *http.Request) bool { return true }, // Allow all origins
 	}
-	clients   = make(map[*websocket.Conn]bool)
-	broadcast = make(chan []byte)
-	mutex     = &sync.RWMutex{}
+	clients  = make(map[*client]struct{})
+	mutex    = &sync.RWMutex{}
+	handlers = make(map[string]MessageHandler)
+
+	// connectedClients is the number of open connections, published with the
+	// other expvar metrics.
+	connectedClients = expvar.NewInt("websocket_clients")
+)
+
+// timeouts are the keepalive and deadline settings of a connection.
+type timeouts struct {
+	ping, pong, write time.Duration
+}
+
+// connectionTimeouts reads the WebSocket settings, falling back to the
+// defaults for values that are not positive. Pings are sent well within the
+// pong timeout so a live client always answers in time.
+func connectionTimeouts() timeouts {
+	cfg := config.AppConfig.Web.WebSocket
+	t := timeouts{
+		ping:  secondsOr(cfg.PingIntervalSecs, 30),
+		pong:  secondsOr(cfg.PongTimeoutSecs, 60),
+		write: secondsOr(cfg.WriteTimeoutSecs, 10),
+	}
+	if t.ping >= t.pong {
+		t.ping = t.pong * 9 / 10
+	}
+	return t
+}
+
+func secondsOr(secs, def int) time.Duration {
+	if secs <= 0 {
+		secs = def
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// ClientCount returns the number of connected clients.
+func ClientCount() int {
+	return int(connectedClients.Value())
+}
+
+// Topics group the messages pushed to clients, which only receive the topics
+// they subscribe to.
+const (
+	TopicStatus     = "status"     // status_update
+	TopicMeters     = "meters"     // meter_update, at a high rate
+	TopicLogs       = "logs"       // log_entry for every server log record
+	TopicJobs       = "jobs"       // job_updated
+	TopicRecordings = "recordings" // changes to recordings and their markers
+)
+
+var (
+	// topics are the names clients may subscribe to.
+	topics = map[string]bool{
+		TopicStatus:     true,
+		TopicMeters:     true,
+		TopicLogs:       true,
+		TopicJobs:       true,
+		TopicRecordings: true,
+	}
+	// defaultTopics are subscribed on connect unless the client names its own
+	// with the topics query parameter.
+	defaultTopics = []string{TopicStatus, TopicJobs, TopicRecordings}
+
+	snapshots = make(map[string]SnapshotFunc)
 )
 
-// Handler upgrades HTTP to WebSocket and manages connection lifecycle.
+// SnapshotFunc returns a message describing the current state of a topic.
+type SnapshotFunc func() (eventType string, payload any)
+
+// OnSubscribe registers fn to produce the message sent to a client as soon as
+// it subscribes to topic, including on connect, so it does not have to wait
+// for the next change. Snapshots must be registered before clients connect.
+func OnSubscribe(topic string, fn SnapshotFunc) {
+	snapshots[topic] = fn
+}
+
+// client is a connection with its own queue of outgoing messages, drained by
+// a dedicated writer goroutine so broadcasting never waits on the network.
+// Commands it sends run in order on another goroutine, so a slow one does
+// not stop the reader from answering pings.
+type client struct {
+	conn       *websocket.Conn
+	remoteAddr string
+	timeouts   timeouts
+	send       chan []byte
+	commands   chan []byte
+	done       chan struct{} // closed when the connection is shut down
+	closeOnce  sync.Once
+	topics     map[string]bool // guarded by mutex
+}
+
+// subscription is the payload of subscribe and unsubscribe messages.
+type subscription struct {
+	Topics []string `json:"topics"`
+}
+
+// MessageHandler runs a command received from a client and returns the
+// result sent back to it. A *common.WSError is reported to the client as is;
+// any other error as an internal error.
+type MessageHandler func(payload json.RawMessage) (any, error)
+
+// OnMessage registers fn for client commands of the given type. Handlers must
+// be registered before clients connect.
+func OnMessage(msgType string, fn MessageHandler) {
+	handlers[msgType] = fn
+}
+
+// Handler upgrades HTTP to WebSocket and manages connection lifecycle. The
+// optional topics query parameter is a comma-separated list of the topics to
+// subscribe to instead of the defaults; it may be empty.
 func Handler(w http.ResponseWriter, r *http.Request) {
+	initial := defaultTopics
+	if r.URL.Query().Has("topics") {
+		initial = nil
+		for _, topic := range strings.Split(r.URL.Query().Get("topics"), ",") {
+			if topic = strings.TrimSpace(topic); topic == "" {
+				continue
+			}
+			if !topics[topic] {
+				http.Error(w, fmt.Sprintf("unknown topic %q", topic), http.StatusBadRequest)
+				return
+			}
+			initial = append(initial, topic)
+		}
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		slogger.Log.Error("Failed to upgrade WebSocket connection", "err", err)
 		return
 	}
-	defer ws.Close()
 
-	// Register client
+	c := &client{
+		conn:       ws,
+		remoteAddr: r.RemoteAddr,
+		timeouts:   connectionTimeouts(),
+		send:       make(chan []byte, sendQueueSize),
+		commands:   make(chan []byte, commandQueueSize),
+		done:       make(chan struct{}),
+		topics:     make(map[string]bool, len(initial)),
+	}
+	for _, topic := range initial {
+		c.topics[topic] = true
+	}
+	if limit := config.AppConfig.Web.WebSocket.MaxMessageBytes; limit > 0 {
+		ws.SetReadLimit(limit)
+	}
+	// Any message, including the pong answering a ping, proves the client is
+	// still there. Without one before the deadline the read below fails.
+	ws.SetReadDeadline(time.Now().Add(c.timeouts.pong))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(c.timeouts.pong))
+	})
+
 	mutex.Lock()
-	clients[ws] = true
+	clients[c] = struct{}{}
 	mutex.Unlock()
-	slogger.Log.Info("WebSocket client connected", "remote_addr", r.RemoteAddr)
+	connectedClients.Add(1)
+	slogger.Log.Info("WebSocket client connected", "remote_addr", r.RemoteAddr, "clients", ClientCount())
+
+	c.sendSnapshots(initial)
+	go c.writeLoop()
+	go c.commandLoop()
+	var readErr error
+	defer func() {
+		mutex.Lock()
+		delete(clients, c)
+		mutex.Unlock()
+		connectedClients.Add(-1)
+		c.close()
+		slogger.Log.Info("WebSocket client disconnected", "remote_addr", r.RemoteAddr, "reason", readErr, "clients", ClientCount())
+	}()
 
-	// This loop is necessary to detect when a client disconnects.
+	// Read until the client disconnects, dispatching the messages it sends.
 	for {
-		if _, _, err := ws.ReadMessage(); err != nil {
-			slogger.Log.Info("WebSocket client disconnected", "remote_addr", r.RemoteAddr)
-			mutex.Lock()
-			delete(clients, ws)
-			mutex.Unlock()
-			break
+		var data []byte
+		if _, data, readErr = ws.ReadMessage(); readErr != nil {
+			return
+		}
+		ws.SetReadDeadline(time.Now().Add(c.timeouts.pong))
+		select {
+		case c.commands <- data:
+		default:
+			slogger.Log.Warn("WebSocket client sent too many commands, closing client", "remote_addr", r.RemoteAddr)
+			readErr = errors.New("command queue full")
+			return
 		}
 	}
 }
 
-// HandleMessages listens to the broadcast channel and forwards messages to clients.
-// This must be started as a goroutine.
-func HandleMessages() {
+// commandLoop runs the commands a client sends, one at a time and in the
+// order they arrived, until the connection is closed.
+func (c *client) commandLoop() {
 	for {
-		msg := <-broadcast
-		mutex.RLock()
-		// Send message to all clients
-		for client := range clients {
-			err := client.WriteMessage(websocket.TextMessage, msg)
-			if err != nil {
-				slogger.Log.Warn("WebSocket write error, closing client", "err", err, "remote_addr", client.RemoteAddr().String())
-				client.Close()
-				// Safely remove the client inside the read-lock is tricky.
-				// For simplicity, we let the read loop handle removal.
+		select {
+		case <-c.done:
+			return
+		case data := <-c.commands:
+			dispatch(c, data)
+		}
+	}
+}
+
+// writeLoop sends queued messages and periodic pings until the connection is
+// closed.
+func (c *client) writeLoop() {
+	ping := time.NewTicker(c.timeouts.ping)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-c.done:
+			return
+		case <-ping.C:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.timeouts.write))
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.timeouts.write))
+			err = c.conn.WriteMessage(websocket.TextMessage, msg)
+		}
+		if err != nil {
+			select {
+			case <-c.done: // closed while writing; already reported
+			default:
+				slogger.Log.Warn("WebSocket write error, closing client", "err", err, "remote_addr", c.remoteAddr)
+				c.close()
 			}
+			return
 		}
-		mutex.RUnlock()
 	}
 }
 
-// BroadcastStatus sends the current AudioStatus to all connected clients.
-func BroadcastStatus(status common.AudioStatus) {
-	// Create a message envelope.
-	msg := struct {
-		Type    string             `json:"type"`
-		Payload common.AudioStatus `json:"payload"`
-	}{
-		Type:    "status_update",
-		Payload: status,
+// close shuts the connection down, which also ends its read loop. It is safe
+// to call more than once and from any goroutine.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// enqueue queues msg without blocking. It reports false if the queue is full,
+// in which case the client is disconnected.
+func (c *client) enqueue(msg []byte) bool {
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	default:
+		c.close()
+		return false
+	}
+	return true
+}
+
+// push queues msg like enqueue and reports a client dropped for being too
+// slow. It must not be called with mutex held.
+func (c *client) push(msg []byte) {
+	if !c.enqueue(msg) {
+		slogger.Log.Warn("WebSocket client too slow, closing client", "remote_addr", c.remoteAddr)
+	}
+}
+
+// sendSnapshots queues the current state of each of the given topics that has one.
+func (c *client) sendSnapshots(subscribed []string) {
+	for _, topic := range subscribed {
+		fn, ok := snapshots[topic]
+		if !ok {
+			continue
+		}
+		eventType, payload := fn()
+		if data, err := encode(eventType, payload); err == nil {
+			c.push(data)
+		}
+	}
+}
+
+// dispatch decodes a command from a client, runs it and, if the command has
+// an ID, replies with its result. Subscriptions are handled here, as they
+// belong to the connection.
+func dispatch(c *client, data []byte) {
+	var msg common.WSMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		slogger.Log.Warn("Ignoring malformed WebSocket message", "err", err, "remote_addr", c.remoteAddr)
+		c.reply(common.WSMessage{Type: "error"}, nil, &common.WSError{Code: http.StatusBadRequest, Message: "malformed message: " + err.Error()})
+		return
+	}
+
+	var result any
+	var err error
+	var added []string
+	switch msg.Type {
+	case "subscribe", "unsubscribe":
+		result, added, err = c.subscribe(msg.Payload, msg.Type == "subscribe")
+	default:
+		fn, ok := handlers[msg.Type]
+		if !ok {
+			slogger.Log.Warn("Ignoring unknown WebSocket message", "type", msg.Type, "remote_addr", c.remoteAddr)
+			err = &common.WSError{Code: http.StatusNotFound, Message: fmt.Sprintf("unknown command %q", msg.Type)}
+			break
+		}
+		result, err = fn(msg.Payload)
+		if err != nil {
+			slogger.Log.Warn("WebSocket message failed", "err", err, "type", msg.Type, "remote_addr", c.remoteAddr)
+		}
+	}
+	if msg.ID != "" {
+		c.reply(msg, result, err)
+	}
+	c.sendSnapshots(added)
+}
+
+// reply queues the response to a command.
+func (c *client) reply(req common.WSMessage, result any, err error) {
+	resp := common.WSMessage{ID: req.ID, Type: req.Type}
+	if err == nil && result != nil {
+		payload, merr := json.Marshal(result)
+		if merr != nil {
+			err = merr
+		}
+		resp.Payload = payload
+	}
+	if err != nil {
+		var wsErr *common.WSError
+		if !errors.As(err, &wsErr) {
+			wsErr = &common.WSError{Code: http.StatusInternalServerError, Message: err.Error()}
+		}
+		resp.Payload, resp.Error = nil, wsErr
 	}
 
-	payloadBytes, err := json.Marshal(msg)
+	data, err := json.Marshal(resp)
 	if err != nil {
-		slogger.Log.Error("Failed to marshal status for broadcast", "err", err)
+		slogger.Log.Error("Failed to marshal WebSocket reply", "err", err, "type", req.Type)
 		return
 	}
+	c.push(data)
+}
 
-	// Send the marshaled message to the broadcast channel.
-	broadcast <- payloadBytes
+// subscribe adds or removes the topics listed in a subscription payload. It
+// returns the client's topics afterwards and those newly added.
+func (c *client) subscribe(payload json.RawMessage, on bool) (subscription, []string, error) {
+	var sub subscription
+	if err := json.Unmarshal(payload, &sub); err != nil {
+		return sub, nil, &common.WSError{Code: http.StatusBadRequest, Message: "invalid payload: " + err.Error()}
+	}
+	for _, topic := range sub.Topics {
+		if !topics[topic] {
+			return sub, nil, &common.WSError{Code: http.StatusBadRequest, Message: fmt.Sprintf("unknown topic %q", topic)}
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	var added []string
+	for _, topic := range sub.Topics {
+		if on && !c.topics[topic] {
+			c.topics[topic] = true
+			added = append(added, topic)
+		} else if !on {
+			delete(c.topics, topic)
+		}
+	}
+	current := subscription{Topics: make([]string, 0, len(c.topics))}
+	for topic := range c.topics {
+		current.Topics = append(current.Topics, topic)
+	}
+	slices.Sort(current.Topics)
+	return current, added, nil
+}
+
+// HasSubscribers reports whether any connected client subscribes to topic,
+// so producers can skip work nobody would receive.
+func HasSubscribers(topic string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for c := range clients {
+		if c.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver queues a message for every client subscribed to topic, or for all
+// clients if topic is empty. It never blocks on a client.
+func deliver(topic string, msg []byte) {
+	var dropped []*client
+	mutex.RLock()
+	for c := range clients {
+		if topic != "" && !c.topics[topic] {
+			continue
+		}
+		if !c.enqueue(msg) {
+			dropped = append(dropped, c)
+		}
+	}
+	mutex.RUnlock()
+
+	// Logged outside the lock, as log records are themselves delivered.
+	for _, c := range dropped {
+		slogger.Log.Warn("WebSocket client too slow, closing client", "remote_addr", c.remoteAddr)
+	}
+}
+
+// encode wraps a payload in the envelope of a pushed message.
+func encode(eventType string, payload any) ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Type    string `json:"type"`
+		Payload any    `json:"payload"`
+	}{
+		Type:    eventType,
+		Payload: payload,
+	})
+	if err != nil {
+		slogger.Log.Error("Failed to marshal event for broadcast", "err", err, "type", eventType)
+	}
+	return data, err
+}
+
+// BroadcastStatus sends the current AudioStatus to the clients subscribed to status.
+func BroadcastStatus(status common.AudioStatus) {
+	Publish(TopicStatus, "status_update", status)
+}
+
+// BroadcastEvent sends a typed message with a JSON payload to all connected clients.
+func BroadcastEvent(eventType string, payload any) {
+	Publish("", eventType, payload)
+}
+
+// Publish sends a typed message with a JSON payload to the clients subscribed
+// to topic, or to all clients if topic is empty.
+func Publish(topic, eventType string, payload any) {
+	if data, err := encode(eventType, payload); err == nil {
+		deliver(topic, data)
+	}
 }
 
 // Broadcast sends a message to all connected WebSocket clients.
 func Broadcast(message string) {
-	broadcast <- []byte(message)
+	deliver("", []byte(message))
 }
@@ -0,0 +1,215 @@
+package websocket
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"nixon/internal/common"
+	"nixon/internal/config"
+	"nixon/internal/slogger"
+)
+
+func TestMain(m *testing.M) {
+	slogger.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+// connect starts a server running Handler and dials it with query, which
+// selects the topics. Both are closed when the test ends.
+func connect(t *testing.T, query string) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(Handler))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitClients waits until n clients are connected.
+func waitClients(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for ClientCount() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients connected, want %d", ClientCount(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// call sends a command with id and an optional payload and returns its reply.
+func call(t *testing.T, conn *websocket.Conn, id, msgType string, payload ...any) common.WSMessage {
+	t.Helper()
+	msg := common.WSMessage{ID: id, Type: msgType}
+	if len(payload) > 0 {
+		msg.Payload, _ = json.Marshal(payload[0])
+	}
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatalf("sending %s: %v", msgType, err)
+	}
+	for {
+		var msg common.WSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for the reply to %s: %v", msgType, err)
+		}
+		if msg.ID == id {
+			return msg
+		}
+	}
+}
+
+func TestSlowCommandKeepsConnection(t *testing.T) {
+	saved := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = saved })
+	config.AppConfig.Web.WebSocket.PongTimeoutSecs = 1
+
+	OnMessage("test_slow", func(json.RawMessage) (any, error) {
+		time.Sleep(1500 * time.Millisecond) // longer than the pong timeout
+		return "slow", nil
+	})
+	OnMessage("test_echo", func(json.RawMessage) (any, error) {
+		return "echo", nil
+	})
+
+	conn := connect(t, "?topics=")
+
+	for i, msgType := range []string{"test_slow", "test_echo"} {
+		reply := call(t, conn, strconv.Itoa(i), msgType)
+		if reply.Error != nil {
+			t.Fatalf("%s failed: %v", msgType, reply.Error.Message)
+		}
+		var result string
+		if err := json.Unmarshal(reply.Payload, &result); err != nil || result != strings.TrimPrefix(msgType, "test_") {
+			t.Fatalf("%s replied %s", msgType, reply.Payload)
+		}
+	}
+}
+
+func TestSlowClientIsDropped(t *testing.T) {
+	waitClients(t, 0)
+	slow := connect(t, "?topics="+TopicJobs)
+	waitClients(t, 1)
+
+	// The slow client never reads. Once the network buffers and its queue are
+	// full it is disconnected, without Publish ever waiting for it.
+	payload := strings.Repeat("x", 32<<10)
+	start := time.Now()
+	for range 2000 {
+		Publish(TopicJobs, "job_updated", payload)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("publishing took %v", elapsed)
+	}
+	waitClients(t, 0)
+	if got := expvar.Get("websocket_clients").String(); got != "0" {
+		t.Fatalf("websocket_clients = %s after the drop, want 0", got)
+	}
+
+	slow.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := slow.ReadMessage(); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("dropped client's connection is still open")
+			}
+			break
+		}
+	}
+}
+
+func TestUnresponsiveClientIsClosed(t *testing.T) {
+	saved := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = saved })
+	config.AppConfig.Web.WebSocket.PongTimeoutSecs = 1
+	waitClients(t, 0)
+
+	// A client reading its messages answers pings; one that stopped reading,
+	// like a phone gone to sleep, does not.
+	live := connect(t, "?topics=")
+	go func() {
+		for {
+			if _, _, err := live.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	start := time.Now()
+	connect(t, "?topics=")
+	waitClients(t, 2)
+
+	waitClients(t, 1)
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 2*time.Second {
+		t.Fatalf("unresponsive client closed after %v, want the 1s pong timeout", elapsed)
+	}
+	time.Sleep(2 * time.Second)
+	if n := ClientCount(); n != 1 {
+		t.Fatalf("%d clients connected, want the live one to stay", n)
+	}
+}
+
+// next reads the next message pushed to conn.
+func next(t *testing.T, conn *websocket.Conn) common.WSMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg common.WSMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	return msg
+}
+
+func TestTopicSubscriptions(t *testing.T) {
+	OnSubscribe(TopicStatus, func() (string, any) {
+		return "status_update", common.AudioStatus{State: common.StateArmed}
+	})
+	conn := connect(t, "?topics="+TopicJobs)
+
+	// Only the jobs event reaches the client, which has no status snapshot
+	// yet as it did not subscribe to status.
+	Publish(TopicMeters, "meter_update", "meters")
+	Publish(TopicRecordings, "recording_updated", "recordings")
+	Publish(TopicJobs, "job_updated", "jobs")
+	if msg := next(t, conn); msg.Type != "job_updated" || string(msg.Payload) != `"jobs"` {
+		t.Fatalf("got %s %s, want only the jobs event", msg.Type, msg.Payload)
+	}
+
+	// Subscribing to status delivers its snapshot right after the reply.
+	if reply := call(t, conn, "sub", "subscribe", subscription{Topics: []string{TopicStatus}}); reply.Error != nil ||
+		string(reply.Payload) != `{"topics":["jobs","status"]}` {
+		t.Fatalf("subscribe replied %s, %v", reply.Payload, reply.Error)
+	}
+	msg := next(t, conn)
+	var status common.AudioStatus
+	if msg.Type != "status_update" || json.Unmarshal(msg.Payload, &status) != nil || status.State != common.StateArmed {
+		t.Fatalf("got %s %s, want the status snapshot", msg.Type, msg.Payload)
+	}
+
+	// After unsubscribing from jobs only status events arrive.
+	if reply := call(t, conn, "unsub", "unsubscribe", subscription{Topics: []string{TopicJobs}}); reply.Error != nil ||
+		string(reply.Payload) != `{"topics":["status"]}` {
+		t.Fatalf("unsubscribe replied %s, %v", reply.Payload, reply.Error)
+	}
+	Publish(TopicJobs, "job_updated", "jobs")
+	BroadcastStatus(common.AudioStatus{State: common.StateRecording})
+	if msg := next(t, conn); msg.Type != "status_update" {
+		t.Fatalf("got %s %s, want the status update", msg.Type, msg.Payload)
+	}
+
+	if reply := call(t, conn, "bad", "subscribe", subscription{Topics: []string{"secrets"}}); reply.Error == nil || reply.Error.Code != http.StatusBadRequest {
+		t.Fatalf("subscribing to an unknown topic replied %+v", reply)
+	}
+}